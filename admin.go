@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tilegame/gameserver/commander"
+)
+
+// adminCenter contains the commands of the admin console, which reads
+// them from stdin when the server is started with -io.  They are
+// written in the function syntax, like disable("chat").
+var adminCenter = &commander.Center{}
+
+func init() {
+	adminCenter.MustRegister("help", &commander.Function{
+		Func:        adminCenter.HelpMessage,
+		Description: "Lists the admin commands.",
+	})
+	adminCenter.MustRegister("commands", &commander.Function{
+		Func:        adminCommands,
+		Description: "Lists the /ws commands, and whether they are enabled.",
+	})
+	adminCenter.MustRegister("disable", &commander.Function{
+		Func:        commandCenter.Disable,
+		Params:      []string{"name"},
+		Description: "Turns off a /ws command, until it is enabled again.",
+	})
+	adminCenter.MustRegister("enable", &commander.Function{
+		Func:        commandCenter.Enable,
+		Params:      []string{"name"},
+		Description: "Turns a disabled /ws command back on.",
+	})
+	adminCenter.MustRegister("rollkey", &commander.Function{
+		Func:        adminRollKey,
+		Description: "Signs new session cookies with a new key, while the old key keeps working for a while.",
+	})
+}
+
+func adminCommands() map[string]bool {
+	commands := map[string]bool{}
+	for _, name := range commandCenter.Names() {
+		commands[name] = commandCenter.Enabled(name)
+	}
+	return commands
+}
+
+// adminRollKey rotates the cookie keys, and says how many of them are
+// still accepted.
+func adminRollKey() (string, error) {
+	if err := cookieServer.RotateKey(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("new key in use; %d keys accepted.", len(cookieServer.Keys())), nil
+}
+
+// handleAdminCommand runs a line from the admin console, and prints
+// the result.
+func handleAdminCommand(line string) {
+	result, err := adminCenter.CallWithFunctionString(line)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if result == nil {
+		result = "ok"
+	}
+	fmt.Println(result)
+}
@@ -0,0 +1,135 @@
+/*
+Package codec encodes the messages sent over the websockets.
+
+Messages are JSON, unless a client asks for a binary encoding by
+naming it as the websocket subprotocol:
+
+	new WebSocket("wss://example.com/ws", ["msgpack"])
+
+The responses and broadcasts are Go values, which are encoded with the
+codec of each client, so nothing is translated from JSON on the way.
+Structs use the names in their json tags in every codec, so a message
+has the same shape no matter how it is encoded.  To keep the messages
+small, MessagePack sends floats that are whole numbers as integers, and
+CBOR sends floats with as few bytes as they fit in.
+*/
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes and decodes values.  The Name is the websocket
+// subprotocol that selects the codec, and Binary tells if the messages
+// are sent as binary frames rather than text.
+type Codec interface {
+	Name() string
+	Binary() bool
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSON        Codec = jsonCodec{}
+	MessagePack Codec = msgpackCodec{}
+	CBOR        Codec = cborCodec{}
+)
+
+// Codecs are the codecs that a client can choose from, in the order
+// that the server prefers them.
+var Codecs = []Codec{MessagePack, CBOR, JSON}
+
+// Subprotocols returns the names of the Codecs, for a websocket
+// upgrader.
+func Subprotocols() []string {
+	names := make([]string, len(Codecs))
+	for i, c := range Codecs {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+// Lookup finds a codec by its name.  An empty name is JSON, since that
+// is what clients get when they don't ask for a subprotocol.
+func Lookup(name string) (Codec, bool) {
+	if name == "" {
+		return JSON, true
+	}
+	for _, c := range Codecs {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Binary() bool                               { return false }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+func (msgpackCodec) Binary() bool { return true }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	dec.SetMapDecoder(decodeStringMap)
+	return dec.Decode(v)
+}
+
+// decodeStringMap decodes maps with keys of any type, as long as they
+// are strings, which is all that JSON allows.
+func decodeStringMap(dec *msgpack.Decoder) (interface{}, error) {
+	n, err := dec.DecodeMapLen()
+	if err != nil || n < 0 {
+		return nil, err
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := dec.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+		if m[k], err = dec.DecodeInterface(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+type cborCodec struct{}
+
+var (
+	cborEnc, _ = cbor.EncOptions{ShortestFloat: cbor.ShortestFloat16}.EncMode()
+	cborDec, _ = cbor.DecOptions{
+		DefaultMapType:  reflect.TypeOf(map[string]interface{}(nil)),
+		MaxNestedLevels: 64,
+	}.DecMode()
+)
+
+func (cborCodec) Name() string                               { return "cbor" }
+func (cborCodec) Binary() bool                               { return true }
+func (cborCodec) Marshal(v interface{}) ([]byte, error)      { return cborEnc.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v interface{}) error { return cborDec.Unmarshal(data, v) }
@@ -0,0 +1,99 @@
+package codec
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type player struct {
+	ID   int      `json:"PlayerId"`
+	Name string   `json:"-"`
+	X    float64  `json:"x"`
+	Tags []string `json:",omitempty"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	messages := []string{
+		`{"Name":"move","Args":["alice",3,4]}`,
+		`{"jsonrpc":"2.0","method":"move","params":{"name":"alice","x":-3,"y":4.5},"id":"a"}`,
+		`[1,2.5,"three",true,null,{"nested":[[]]}]`,
+		`"move(\"alice\", 3, 4)"`,
+	}
+	for _, c := range Codecs {
+		for _, m := range messages {
+			var v interface{}
+			json.Unmarshal([]byte(m), &v)
+			b, err := c.Marshal(v)
+			if err != nil {
+				t.Errorf("%s: Marshal(%s): %v", c.Name(), m, err)
+				continue
+			}
+			var back interface{}
+			if err := c.Unmarshal(b, &back); err != nil {
+				t.Errorf("%s: Unmarshal(%s): %v", c.Name(), m, err)
+				continue
+			}
+			out, _ := json.Marshal(back)
+			if !sameJSON(out, []byte(m)) {
+				t.Errorf("%s:\n got: %s\nwant: %s", c.Name(), out, m)
+			}
+		}
+	}
+}
+
+// sameJSON compares two JSON texts, ignoring the order of object keys.
+func sameJSON(a, b []byte) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// TestStructs checks that structs are encoded with the names of their
+// json tags, whatever the codec.
+func TestStructs(t *testing.T) {
+	p := player{ID: 136, Name: "secret", X: 1.5}
+	for _, c := range Codecs {
+		b, err := c.Marshal(map[string]player{"alice": p})
+		if err != nil {
+			t.Errorf("%s: %v", c.Name(), err)
+			continue
+		}
+		var v interface{}
+		if err := c.Unmarshal(b, &v); err != nil {
+			t.Errorf("%s: %v", c.Name(), err)
+			continue
+		}
+		out, _ := json.Marshal(v)
+		if want := `{"alice":{"PlayerId":136,"x":1.5}}`; string(out) != want {
+			t.Errorf("%s:\n got: %s\nwant: %s", c.Name(), out, want)
+		}
+	}
+
+	// the binary codecs are smaller, which is the point of them.
+	p.X = 3
+	j, _ := JSON.Marshal(p)
+	for _, c := range []Codec{MessagePack, CBOR} {
+		if b, _ := c.Marshal(p); len(b) >= len(j) {
+			t.Errorf("%s: %d bytes isn't smaller than the %d bytes of JSON", c.Name(), len(b), len(j))
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"", "json", "msgpack", "cbor"} {
+		c, ok := Lookup(name)
+		if !ok || (name != "" && c.Name() != name) || (name == "" && c != JSON) {
+			t.Errorf("Lookup(%q) = %v, %v", name, c, ok)
+		}
+	}
+	if _, ok := Lookup("xml"); ok {
+		t.Error("Lookup(xml) should fail")
+	}
+	var v interface{}
+	if err := MessagePack.Unmarshal([]byte{0xc1}, &v); err == nil {
+		t.Error("expected an error for an invalid message")
+	}
+}
@@ -1,157 +1,284 @@
 package commander
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"math"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	errTypeMismatch = `ParameterTypeError: %v; Got: %v; Expected: %v;`
 	errNotExist     = `Command %v Not Found.`
 	errNotFunction  = `The command %v is not a function.`
+	errArgumentType = `ParameterTypeError: %v; Argument %d%s;`
 )
 
 func errTypes(name, got, expect interface{}) error {
-	return fmt.Errorf(errTypeMismatch, name, got, expect)
+	return &ArgumentError{
+		Command: fmt.Sprint(name),
+		Message: fmt.Sprintf(errTypeMismatch, name, got, expect),
+	}
+}
+
+func errArgument(name string, i int, err *conversionError) error {
+	return &ArgumentError{
+		Command: name,
+		Message: fmt.Sprintf(errArgumentType, name, i+1, err),
+	}
+}
+
+// NotFoundError is returned by Call when there is no command by the
+// given name.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf(errNotExist, e.Name)
+}
+
+// ArgumentError is returned by Call when the arguments can't be used
+// as the parameters of the command.
+type ArgumentError struct {
+	Command string
+	Message string
+}
+
+func (e *ArgumentError) Error() string {
+	return e.Message
+}
+
+// DisabledError is returned by Call when the command has been turned
+// off with Disable.
+type DisabledError struct {
+	Name string
+}
+
+func (e *DisabledError) Error() string {
+	return fmt.Sprintf("The command %v is disabled.", e.Name)
 }
 
 // Response is the structure of output from the called function.  If
-// the call was successful, Error == nil, and the Result is an array
-// of ouput. If there is an error, then Result == nil and Error ==
-// "some error string".  Note: when calling Void functions:
-// len(Result) = 0.
+// the call was successful, Error == nil, and the Result is the output
+// of the function. If there is an error, then Result == nil and Error ==
+// "some error string".  Note: when calling Void functions, or functions
+// that only return an error: Result == nil.  Functions with multiple
+// outputs have their Result as an array: []interface{}.
 type Response struct {
 	Result interface{}
 	Error  interface{}
 }
 
 // Command is the structure of a callable command.  The Arguments are
-// typed-checked at runtime.
+// typed-checked at runtime.  Named arguments are matched to the
+// parameter names of a Function.
 type Command struct {
-	Name string
-	Args []interface{}
+	Name  string
+	Args  []interface{}
+	Named map[string]interface{} `json:",omitempty"`
 }
 
 // Center contains a Map[string]interface{}, which maps a
 // (Function Name) to its (Function).  This enables a valid 'Command' to
 // lookup the function by name.
 //
-// When Creating a CommandCenter, make sure to create the map.
-// Otherwise, using the method Call() will always return the error
-// "Command Doesn't Exist".
+// When Creating a CommandCenter, make sure to create the map, or add
+// the commands with Register().  Otherwise, using the method Call()
+// will always return the error "Command Doesn't Exist".  The map
+// should only be filled in directly before the Center is used.  After
+// that, Register, Replace and Unregister can safely change the
+// commands while they are being called.
+//
+// Timeout is how long a command may take before Call gives up on it,
+// unless the Function has a Timeout of its own.  Zero means that there
+// is no limit.
 type Center struct {
 	FuncMap map[string]interface{}
+	Timeout time.Duration
+
+	mu       sync.RWMutex
+	disabled map[string]bool
+	chain    Handler // the outermost middleware.
+	end      *link   // the innermost middleware.
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// NewResponse wraps the output of Call in a Response.
+func NewResponse(result interface{}, err error) Response {
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{Result: result}
 }
 
 // CallWithCommand is the same as Call, but using the predefined
 // Command data structure.
 func (c *Center) CallWithCommand(cmd *Command) (interface{}, error) {
-	return c.Call(cmd.Name, cmd.Args...)
+	return c.CallNamed(context.Background(), cmd.Name, cmd.Args, cmd.Named)
 }
 
 // Call attempts to call the function <name>(<args>...) and does type
-// checks to confirm that it can be done.
+// checks to confirm that it can be done.  It is the same as
+// CallContext, using context.Background().
 func (c *Center) Call(name string, args ...interface{}) (interface{}, error) {
+	return c.CallContext(context.Background(), name, args...)
+}
 
-	// Retrieve the func:<name> from the map.
-	f, ok := c.FuncMap[name]
+// CallContext attempts to call the function <name>(<args>...) and does
+// type checks to confirm that it can be done.
+//
+// If the first parameter of the function is a context.Context, it is
+// given ctx, and the args are matched to the rest of the parameters.
+// This is how a function can find out who called it.
+//
+// The outputs of the function are translated like so:
+//
+//	func()                  -> nil, nil
+//	func() T                -> T, nil
+//	func() error            -> nil, error
+//	func() (T, error)       -> T, error
+//	func() (T1, T2, ...)    -> []interface{}{T1, T2, ...}, nil
+//
+// When the function returns a non-nil error, the other outputs are
+// discarded.
+func (c *Center) CallContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	return c.CallNamed(ctx, name, args, nil)
+}
 
-	// check if func:<name> exists.
-	if !ok {
-		return nil, fmt.Errorf(errNotExist, name)
+// CallNamed is the same as CallContext, but also accepts named
+// arguments.  They are matched to the parameter names of the Function,
+// and can be given in any order after the positional arguments.
+//
+// A command that panics is recovered, and returns a *PanicError.  This
+// includes panics in the middleware.
+func (c *Center) CallNamed(ctx context.Context, name string, args []interface{}, named map[string]interface{}) (result interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			result, err = nil, newPanicError(name, v)
+		}
+	}()
+	if ctx == nil {
+		ctx = context.Background()
 	}
+	req := &Request{Name: name, Args: args, Named: named}
 
-	// retrieve the reflection of the func.
-	t := reflect.TypeOf(f)
-
-	// confirm that it is a callable func.
-	if t.Kind() != reflect.Func {
-		return nil, fmt.Errorf(errNotFunction, name)
+	// Retrieve the func:<name> from the map.
+	c.mu.RLock()
+	entry, ok := c.FuncMap[name]
+	if !ok && name == DiscoverMethod {
+		entry, ok = c.Discover, true
+	}
+	if ok {
+		req.Function = asFunction(entry)
 	}
+	h := c.handler()
+	c.mu.RUnlock()
+	return h(ctx, req)
+}
+
+// call is the innermost Handler, which does the actual work of
+// CallNamed once the middleware is done.
+func (c *Center) call(ctx context.Context, req *Request) (interface{}, error) {
 
-	// retrieve parameter types from target function.
-	var paramTypes []reflect.Type
-	for i := 0; i < t.NumIn(); i++ {
-		paramTypes = append(paramTypes, t.In(i))
+	// check if func:<name> exists, and is allowed to be called.
+	if req.Function == nil {
+		return nil, &NotFoundError{req.Name}
+	}
+	if !c.Enabled(req.Name) {
+		return nil, &DisabledError{req.Name}
 	}
 
-	// retieve argument types (and values) from the user's call.
-	var argTypes []reflect.Type
-	var argVals []reflect.Value
-	for _, v := range args {
-		argTypes = append(argTypes, reflect.TypeOf(v))
-		argVals = append(argVals, reflect.ValueOf(v))
+	// confirm that it is a callable func, and retrieve its parameters.
+	f := req.Function
+	sig, err := f.signature(req.Name)
+	if err != nil {
+		return nil, err
 	}
 
-	// compare number of args to params.
-	if len(argTypes) != len(paramTypes) {
-		return nil, errTypes(name, argTypes, paramTypes)
+	// convert the arguments into the parameter types.
+	argVals, err := f.bind(req.Name, sig, req.Args, req.Named)
+	if err != nil {
+		return nil, err
 	}
 
-	// compare argument and parameter types.
-	for i := 0; i < len(paramTypes); i++ {
-		// case:  floating point --> integer conversion.
-		if checkFloatToInt(argTypes[i], paramTypes[i], argVals[i]) {
-			argVals[i] = reflect.ValueOf(int(argVals[i].Float()))
-			continue
-		}
-		if paramTypes[i] != argTypes[i] {
-			return nil, errTypes(name, argTypes, paramTypes)
-		}
+	// give the function a deadline, and pass along the context.
+	timeout := c.timeout(f)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if sig.context {
+		argVals = append([]reflect.Value{reflect.ValueOf(ctx)}, argVals...)
 	}
 
 	// call the function.
-	result := reflect.ValueOf(f).Call(argVals)
-
-	// NOTE:
-	//
-	// This method returns multiple outputs, type []interface{}
-	// output := make([]interface{}, len(result))
+	return invoke(ctx, req.Name, timeout, sig, argVals)
+}
 
-	// for i, v := range result {
-	// 	output[i] = v.Interface()
-	// }
+// unpackResult converts the outputs of a function into the result and
+// error returned by CallContext.
+func unpackResult(t reflect.Type, result []reflect.Value) (interface{}, error) {
 
-	// This method returns a single result, even if there are more.
-	output := result[0].Interface()
+	// a trailing error is split off from the other outputs.
+	if n := t.NumOut(); n > 0 && t.Out(n-1) == errorType {
+		if !isNil(result[n-1]) {
+			return nil, result[n-1].Interface().(error)
+		}
+		result = result[:n-1]
+	}
 
+	switch len(result) {
+	case 0:
+		return nil, nil
+	case 1:
+		return result[0].Interface(), nil
+	}
+	output := make([]interface{}, len(result))
+	for i, v := range result {
+		output[i] = v.Interface()
+	}
 	return output, nil
 }
 
-// converts the reflection of a float into a integer if that number is
-// the same.  For example, float(9) converts to int(9), but float(9.1)
-// must remain a float.
-func checkFloatToInt(argT, paramT reflect.Type, argV reflect.Value) bool {
-
-	// Confirm that (arg, param) are in (Float, Int)
-	// If they aren't, then return immediately.
-	if !(argT.Kind() == reflect.Float64 && paramT.Kind() == reflect.Int) {
-		return false
-	}
-
-	// If a floating point equals its floor, then it's an integer.
-	if math.Floor(argV.Float()) == argV.Float() {
+// isNil checks if an error is nil.  An error that holds a nil pointer,
+// like a function returning a nil *MyError as its error, is nil too,
+// even though the error itself isn't.
+func isNil(v reflect.Value) bool {
+	if v.IsNil() {
 		return true
 	}
-
+	switch e := v.Elem(); e.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return e.IsNil()
+	}
 	return false
 }
 
 func (c *Command) String() string {
-	s := c.Name
-	s += "("
-	lastIndex := len(c.Args) - 1
-	for i, v := range c.Args {
-		s += fmt.Sprintf("%#v", v)
-		if i != lastIndex {
-			s += ", "
-		}
+	var args []string
+	for _, v := range c.Args {
+		args = append(args, fmt.Sprintf("%#v", v))
+	}
+	keys := make([]string, 0, len(c.Named))
+	for k := range c.Named {
+		keys = append(keys, k)
 	}
-	s += ")"
-	return s
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("%s=%#v", k, c.Named[k]))
+	}
+	return c.Name + "(" + strings.Join(args, ", ") + ")"
 }
 
 // ------------------------------------------------------------------
@@ -159,15 +286,15 @@ func (c *Command) String() string {
 // ------------------------------------------------------------------
 
 // CallWithJson attempts to call the function using a JSON object that
-// contains Name and Args.  The Structure will match the Command
-// structure.
+// contains Name, Args and optionally Named.  The Structure will match
+// the Command structure.
 func (c *Center) CallWithJson(b []byte) (interface{}, error) {
 	cmd := &Command{}
 	err := json.Unmarshal(b, cmd)
 	if err != nil {
 		return nil, fmt.Errorf("JSON syntax error.")
 	}
-	return c.Call(cmd.Name, cmd.Args...)
+	return c.CallWithCommand(cmd)
 }
 
 // CallFromStrings calls a function in the Command Center using an
@@ -186,38 +313,122 @@ func (c *Center) CallWithStrings(name string, args []string) (interface{}, error
 	return c.CallWithJson([]byte(v))
 }
 
-// CallWithFunctionParser parses a string based on the function
-// syntax: functionName(arg1, arg2, ...) and uses the result to
-// call the function.  Whitespace is removed entirely from the input
-// string.  Trailing commas within the list of arguments are optional
-// because they are removed.
+// CallWithFunctionString parses a string based on the function
+// syntax: functionName(arg1, arg2, name=value, ...) and uses the
+// result to call the function.  See ParseFunctionString for the
+// details: whitespace between the arguments is ignored but kept inside
+// of strings, a single trailing comma is allowed, and named arguments
+// come after the positional ones.
 //
 // Arguments are treated as JSON values, so they follow the JSON
 // encoding definitions.  If an error comes back as
-// "Caller: JSON syntax error", it is most likely because one of the
+// "Parser: JSON syntax error", it is most likely because one of the
 // arguments is improperly formatted.
 func (c *Center) CallWithFunctionString(s string) (interface{}, error) {
-	name, args, err := parseFunctionSyntax(s)
+	cmd, err := ParseFunctionString(s)
 	if err != nil {
-		return "", fmt.Errorf("Parser: %v", err)
+		return "", err
 	}
-	result, err := c.CallWithStrings(name, args)
+	result, err := c.CallWithCommand(cmd)
 	if err != nil {
 		return "", fmt.Errorf("Caller: %v", err)
 	}
 	return result, nil
 }
 
+// Handle calls a command that is written either as a JSON Command,
+// like {"Name": "add", "Args": [1, 2]}, or in the function syntax,
+// like add(1, 2).  The outcome is wrapped in a Response, so that it can
+// be sent straight back to whoever asked for it.  The context is
+// passed along to functions that accept one.
+func (c *Center) Handle(ctx context.Context, b []byte) Response {
+	var cmd *Command
+	var err error
+	if text := bytes.TrimSpace(b); len(text) > 0 && text[0] == '{' {
+		cmd = &Command{}
+		if json.Unmarshal(text, cmd) != nil {
+			err = fmt.Errorf("JSON syntax error.")
+		}
+	} else {
+		cmd, err = ParseFunctionString(string(text))
+	}
+	if err != nil {
+		return NewResponse(nil, err)
+	}
+	return NewResponse(c.CallNamed(ctx, cmd.Name, cmd.Args, cmd.Named))
+}
+
+// HandleValue calls a command from a message that has already been
+// decoded, like one read with a binary codec, and returns what should
+// be sent back.  A string is text, which is handled by HandleJSONRPC
+// or Handle.  An array, or an object with a "jsonrpc" member, is
+// handled by HandleRPC.  Any other object is a Command, and is
+// answered with a Response.
+func (c *Center) HandleValue(ctx context.Context, v interface{}) interface{} {
+	switch m := v.(type) {
+	case string:
+		if IsJSONRPC([]byte(m)) {
+			return c.HandleJSONRPC(ctx, []byte(m))
+		}
+		return c.Handle(ctx, []byte(m))
+	case []interface{}:
+		return c.HandleRPC(ctx, m)
+	case map[string]interface{}:
+		if _, ok := m["jsonrpc"]; ok {
+			return c.HandleRPC(ctx, m)
+		}
+		cmd, err := commandOf(m)
+		if err != nil {
+			return NewResponse(nil, err)
+		}
+		return NewResponse(c.CallNamed(ctx, cmd.Name, cmd.Args, cmd.Named))
+	}
+	return NewResponse(nil, fmt.Errorf("invalid message."))
+}
+
+// commandOf reads a Command out of a decoded object.
+func commandOf(m map[string]interface{}) (*Command, error) {
+	cmd := &Command{}
+	var ok bool
+	if cmd.Name, ok = m["Name"].(string); !ok {
+		return nil, fmt.Errorf("invalid command: Name must be a string.")
+	}
+	if args, found := m["Args"]; found && args != nil {
+		if cmd.Args, ok = args.([]interface{}); !ok {
+			return nil, fmt.Errorf("invalid command: Args must be an array.")
+		}
+	}
+	if named, found := m["Named"]; found && named != nil {
+		if cmd.Named, ok = named.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("invalid command: Named must be an object.")
+		}
+	}
+	return cmd, nil
+}
+
 // HelpMessage produces a string containing a human-readable
 // description of the function names and parameter types.  The
 // functions included in the help message are generated from those in
-// the command center's function map.
+// the command center's function map, sorted by name, along with their
+// descriptions.
 func (c *Center) HelpMessage() string {
 	message := ""
-	for k, v := range c.FuncMap {
-		s := fmt.Sprint(reflect.TypeOf(v))
-		s = strings.Replace(s, "func", k, 1)
+	for _, k := range c.Names() {
+		f, ok := c.Function(k)
+		if !ok {
+			continue
+		}
+		s := k + f.usage()
+		if len(f.Defaults) > 0 {
+			s += fmt.Sprint(" defaults:", f.Defaults)
+		}
+		if !c.Enabled(k) {
+			s += " (disabled)"
+		}
 		message += fmt.Sprint("\n\t", s)
+		if f.Description != "" {
+			message += fmt.Sprint("\n\t\t", f.Description)
+		}
 	}
 	return message
 }
@@ -1,6 +1,7 @@
 package commander
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -38,17 +39,25 @@ func gimmeTrue() bool {
 	return true
 }
 
+func checkPositive(a int) error {
+	if a <= 0 {
+		return fmt.Errorf("%d is not positive", a)
+	}
+	return nil
+}
+
 // ==================================================
 // Creating the Actual Command Center
 // __________________________________________________
 
-var center = Center{map[string]interface{}{
-	"Command1":  command1,
-	"Command2":  command2,
-	"GimmeTrue": gimmeTrue,
-	"Add":       add,
-	"multInt":   multInt,
-	"multFloat": multFloat,
+var center = Center{FuncMap: map[string]interface{}{
+	"Command1":      command1,
+	"Command2":      command2,
+	"GimmeTrue":     gimmeTrue,
+	"Add":           add,
+	"multInt":       multInt,
+	"multFloat":     multFloat,
+	"checkPositive": checkPositive,
 }}
 
 // ==================================================
@@ -163,3 +172,257 @@ func TestStrings(t *testing.T) {
 
 	}
 }
+
+func TestHandle(t *testing.T) {
+	cases := []struct {
+		in     string
+		result interface{}
+		err    string
+	}{
+		{`{"Name": "multInt", "Args": [6, 7]}`, 42, ""},
+		{`  multInt(6, 7)`, 42, ""},
+		{`checkPositive(3)`, nil, ""},
+		{`checkPositive(-3)`, nil, "-3 is not positive"},
+		{`{"Name": "nope", "Args": []}`, nil, "Command nope Not Found."},
+		{`{"Name": `, nil, "JSON syntax error."},
+		{`multInt(6, 7`, nil, "Parser: syntax error: ')' not found."},
+	}
+	for _, c := range cases {
+		r := center.Handle(context.Background(), []byte(c.in))
+		if c.err != "" {
+			if fmt.Sprint(r.Error) != c.err {
+				t.Errorf("%s: expected error %q, got %v", c.in, c.err, r.Error)
+			}
+			continue
+		}
+		if r.Error != nil {
+			t.Errorf("%s: unexpected error %v", c.in, r.Error)
+			continue
+		}
+		if r.Result != c.result {
+			t.Errorf("%s: expected %v, got %v", c.in, c.result, r.Result)
+		}
+	}
+}
+
+type ctxKey struct{}
+
+func whoCalled(ctx context.Context, greeting string) string {
+	return fmt.Sprint(greeting, ", ", ctx.Value(ctxKey{}))
+}
+
+func divide(a, b int) (int, error) {
+	if b == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return a / b, nil
+}
+
+func divmod(a, b int) (int, int) {
+	return a / b, a % b
+}
+
+func nothing() {}
+
+type rootError struct{ msg string }
+
+func (e *rootError) Error() string { return e.msg }
+
+// sqrt returns a nil *rootError as its error when it works, which is
+// still a success, even though the error isn't nil.
+func sqrt(n int) (int, error) {
+	var err *rootError
+	if n < 0 {
+		err = &rootError{"negative number"}
+	}
+	r := 0
+	for (r+1)*(r+1) <= n {
+		r++
+	}
+	return r, err
+}
+
+func TestCallContext(t *testing.T) {
+	c := Center{FuncMap: map[string]interface{}{
+		"whoCalled": whoCalled,
+		"divide":    divide,
+		"divmod":    divmod,
+		"nothing":   nothing,
+		"sqrt":      sqrt,
+	}}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "alice")
+
+	cases := []struct {
+		name   string
+		args   []interface{}
+		result interface{}
+		err    string
+	}{
+		{"whoCalled", []interface{}{"hello"}, "hello, alice", ""},
+		{"divide", []interface{}{7.0, 2.0}, 3, ""},
+		{"divide", []interface{}{7.0, 0.0}, nil, "division by zero"},
+		{"divmod", []interface{}{7.0, 2.0}, []interface{}{3, 1}, ""},
+		{"nothing", nil, nil, ""},
+		{"sqrt", []interface{}{10.0}, 3, ""},
+		{"sqrt", []interface{}{-1.0}, nil, "negative number"},
+		{"whoCalled", []interface{}{ctx, "hello"}, nil,
+			"ParameterTypeError: whoCalled; Got: [*context.valueCtx string]; Expected: [string];"},
+	}
+	for _, tc := range cases {
+		result, err := c.CallContext(ctx, tc.name, tc.args...)
+		if fmt.Sprint(err) != fmt.Sprint(tc.err) && !(err == nil && tc.err == "") {
+			t.Errorf("%s%v: expected error %q, got %v", tc.name, tc.args, tc.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, tc.result) {
+			t.Errorf("%s%v: expected %#v, got %#v", tc.name, tc.args, tc.result, result)
+		}
+	}
+
+	// without a context, functions that want one are given the
+	// background context.
+	result, err := c.Call("whoCalled", "hi")
+	if err != nil || result != "hi, <nil>" {
+		t.Errorf("Call without a context returned %v, %v", result, err)
+	}
+}
+
+func chat(msg string, targets ...string) string {
+	if len(targets) == 0 {
+		return "everyone: " + msg
+	}
+	return strings.Join(targets, ",") + ": " + msg
+}
+
+func walk(name string, steps int, direction string) string {
+	return fmt.Sprint(name, " walks ", steps, " steps ", direction)
+}
+
+func sumAll(ctx context.Context, start int, nums ...int) int {
+	for _, n := range nums {
+		start += n
+	}
+	return start
+}
+
+func TestVariadicAndDefaults(t *testing.T) {
+	c := Center{FuncMap: map[string]interface{}{
+		"chat":   chat,
+		"walk":   WithDefaults(walk, 10, "north"),
+		"sumAll": sumAll,
+		"broken": WithDefaults(walk, "ten", "north"),
+	}}
+
+	cases := []struct {
+		in  string
+		out string
+		err string
+	}{
+		{`chat("hi")`, "everyone: hi", ""},
+		{`chat("hi", "alice", "bob")`, "alice,bob: hi", ""},
+		{`chat()`, "", "Caller: ParameterTypeError: chat; Got: []; Expected: [string []string];"},
+		{`chat("hi", "alice", 3)`, "",
+			"Caller: ParameterTypeError: chat; Argument 3: cannot use 3 (float64) as string;"},
+		{`walk("bob")`, "bob walks 10 steps north", ""},
+		{`walk("bob", 3)`, "bob walks 3 steps north", ""},
+		{`walk("bob", 3, "west")`, "bob walks 3 steps west", ""},
+		{`walk()`, "", "Caller: ParameterTypeError: walk; Got: []; Expected: [string int string];"},
+		{`walk("bob", 3, "west", 1)`, "",
+			"Caller: ParameterTypeError: walk; Got: [string float64 string float64]; Expected: [string int string];"},
+		{`sumAll(1)`, "1", ""},
+		{`sumAll(1, 2, 3)`, "6", ""},
+		{`broken("bob")`, "",
+			"Caller: The command broken has an invalid default for argument 2: cannot use \"ten\" (string) as int."},
+	}
+	for _, tc := range cases {
+		result, err := c.CallWithFunctionString(tc.in)
+		if tc.err != "" {
+			if fmt.Sprint(err) != tc.err {
+				t.Errorf("%s:\n got: %v\nwant: %s", tc.in, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if fmt.Sprint(result) != tc.out {
+			t.Errorf("%s: expected %q, got %q", tc.in, tc.out, result)
+		}
+	}
+}
+
+func TestNamedArguments(t *testing.T) {
+	c := Center{FuncMap: map[string]interface{}{
+		"walk": &Function{
+			Func:     walk,
+			Defaults: []interface{}{10, "north"},
+			Params:   []string{"name", "steps", "direction"},
+		},
+		"chat":   WithParams(chat, "msg", "targets"),
+		"sumAll": WithParams(sumAll, "start"),
+		"add":    add,
+		"broken": WithParams(walk, "name"),
+	}}
+
+	cases := []struct {
+		in  string
+		out string
+		err string
+	}{
+		{`walk(name="bob")`, "bob walks 10 steps north", ""},
+		{`walk("bob", direction="west")`, "bob walks 10 steps west", ""},
+		{`walk(direction="west", steps=2, name="bob")`, "bob walks 2 steps west", ""},
+		{`walk("bob", 3, direction="west")`, "bob walks 3 steps west", ""},
+		{`chat("hi", targets=["alice", "bob"])`, "alice,bob: hi", ""},
+		{`chat(msg="hi")`, "everyone: hi", ""},
+		{`sumAll(start=5)`, "5", ""},
+		{`sumAll(1, 2, 3)`, "6", ""},
+		{`walk(steps=2)`, "", `Caller: ParameterError: walk; missing argument "name";`},
+		{`walk("bob", name="alice")`, "", `Caller: ParameterError: walk; argument "name" given twice;`},
+		{`walk("bob", speed=2)`, "", `Caller: ParameterError: walk; unknown argument "speed";`},
+		{`walk("bob", steps="far")`, "",
+			`Caller: ParameterTypeError: walk; Argument 2 (steps): cannot use "far" (string) as int;`},
+		{`chat("hi", "alice", targets=["bob"])`, "",
+			`Caller: ParameterError: chat; argument "targets" given twice;`},
+		{`chat("hi", targets="bob")`, "",
+			`Caller: ParameterError: chat; argument "targets" must be an array;`},
+		{`add(a=1, b=2)`, "", "Caller: The command add does not accept named arguments."},
+		{`broken("bob")`, "", "Caller: The command broken has 1 parameter names, but 3 parameters."},
+	}
+	for _, tc := range cases {
+		result, err := c.CallWithFunctionString(tc.in)
+		if tc.err != "" {
+			if fmt.Sprint(err) != tc.err {
+				t.Errorf("%s:\n got: %v\nwant: %s", tc.in, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if fmt.Sprint(result) != tc.out {
+			t.Errorf("%s: expected %q, got %q", tc.in, tc.out, result)
+		}
+	}
+}
+
+// TestNamedArgumentsJson checks that CallWithJson passes along the
+// named arguments of a Command, just like the function syntax does.
+func TestNamedArgumentsJson(t *testing.T) {
+	c := Center{FuncMap: map[string]interface{}{
+		"walk": &Function{
+			Func:     walk,
+			Defaults: []interface{}{10, "north"},
+			Params:   []string{"name", "steps", "direction"},
+		},
+	}}
+	result, err := c.CallWithJson([]byte(`{"Name": "walk", "Args": ["bob"], "Named": {"direction": "west"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "bob walks 10 steps west" {
+		t.Errorf("expected the named direction to be used, got %q", result)
+	}
+}
@@ -0,0 +1,307 @@
+package commander
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// conversionError describes why an argument couldn't be converted.  The
+// path points to the part of the argument that was wrong, like "[2]" for
+// the third element of a slice, or ".Pos.X" for a field of a struct.
+type conversionError struct {
+	path string
+	msg  string
+}
+
+func (e *conversionError) Error() string {
+	return e.path + ": " + e.msg
+}
+
+func convErr(v interface{}, t reflect.Type, reason string) *conversionError {
+	msg := fmt.Sprintf("cannot use %s as %v", describe(v), t)
+	if reason != "" {
+		msg += ": " + reason
+	}
+	return &conversionError{msg: msg}
+}
+
+// describe formats a value along with its type, for error messages.
+func describe(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return fmt.Sprintf("%#v (%T)", v, v)
+}
+
+// prefixed adds a step to the path of a conversion error.
+func prefixed(step string, err *conversionError) *conversionError {
+	return &conversionError{path: step + err.path, msg: err.msg}
+}
+
+// convertArg converts a value, usually one that was decoded from JSON,
+// into the type of a parameter.  JSON numbers can become any kind of
+// number as long as they fit, arrays can become slices or arrays,
+// objects can become maps or structs, and strings can become
+// time.Durations.  Pointers are filled in with a newly allocated value.
+func convertArg(v interface{}, t reflect.Type) (reflect.Value, *conversionError) {
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, convErr(v, t, "")
+	}
+
+	val := reflect.ValueOf(v)
+	if val.Type().AssignableTo(t) {
+		return val, nil
+	}
+
+	if t == durationType {
+		if s, ok := v.(string); ok {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return reflect.Value{}, convErr(v, t, "invalid duration")
+			}
+			return reflect.ValueOf(d), nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return convertInt(val, t)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return convertUint(val, t)
+
+	case reflect.Float32, reflect.Float64:
+		return convertFloat(val, t)
+
+	case reflect.String, reflect.Bool:
+		if val.Kind() == t.Kind() {
+			return val.Convert(t), nil
+		}
+
+	case reflect.Ptr:
+		elem, err := convertArg(v, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(elem)
+		return p, nil
+
+	case reflect.Slice, reflect.Array:
+		if arr, ok := v.([]interface{}); ok {
+			return convertList(arr, t)
+		}
+
+	case reflect.Map:
+		if obj, ok := v.(map[string]interface{}); ok {
+			return convertMap(obj, t)
+		}
+
+	case reflect.Struct:
+		if obj, ok := v.(map[string]interface{}); ok {
+			return convertStruct(obj, t)
+		}
+	}
+	return reflect.Value{}, convErr(v, t, "")
+}
+
+func convertInt(val reflect.Value, t reflect.Type) (reflect.Value, *conversionError) {
+	var n int64
+	switch val.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := val.Float()
+		if f != math.Trunc(f) || math.IsInf(f, 0) {
+			return reflect.Value{}, convErr(val.Interface(), t, "not a whole number")
+		}
+		if f < math.MinInt64 || f >= math.MaxInt64 {
+			return reflect.Value{}, convErr(val.Interface(), t, "out of range")
+		}
+		n = int64(f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = val.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := val.Uint()
+		if u > math.MaxInt64 {
+			return reflect.Value{}, convErr(val.Interface(), t, "out of range")
+		}
+		n = int64(u)
+	default:
+		return reflect.Value{}, convErr(val.Interface(), t, "")
+	}
+	out := reflect.New(t).Elem()
+	if out.OverflowInt(n) {
+		return reflect.Value{}, convErr(val.Interface(), t, "out of range")
+	}
+	out.SetInt(n)
+	return out, nil
+}
+
+func convertUint(val reflect.Value, t reflect.Type) (reflect.Value, *conversionError) {
+	var n uint64
+	switch val.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := val.Float()
+		if f != math.Trunc(f) || math.IsInf(f, 0) {
+			return reflect.Value{}, convErr(val.Interface(), t, "not a whole number")
+		}
+		if f < 0 || f >= math.MaxUint64 {
+			return reflect.Value{}, convErr(val.Interface(), t, "out of range")
+		}
+		n = uint64(f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := val.Int()
+		if i < 0 {
+			return reflect.Value{}, convErr(val.Interface(), t, "out of range")
+		}
+		n = uint64(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n = val.Uint()
+	default:
+		return reflect.Value{}, convErr(val.Interface(), t, "")
+	}
+	out := reflect.New(t).Elem()
+	if out.OverflowUint(n) {
+		return reflect.Value{}, convErr(val.Interface(), t, "out of range")
+	}
+	out.SetUint(n)
+	return out, nil
+}
+
+func convertFloat(val reflect.Value, t reflect.Type) (reflect.Value, *conversionError) {
+	var f float64
+	switch val.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f = val.Float()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f = float64(val.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		f = float64(val.Uint())
+	default:
+		return reflect.Value{}, convErr(val.Interface(), t, "")
+	}
+	out := reflect.New(t).Elem()
+	if out.OverflowFloat(f) {
+		return reflect.Value{}, convErr(val.Interface(), t, "out of range")
+	}
+	out.SetFloat(f)
+	return out, nil
+}
+
+func convertList(arr []interface{}, t reflect.Type) (reflect.Value, *conversionError) {
+	var out reflect.Value
+	if t.Kind() == reflect.Array {
+		if len(arr) != t.Len() {
+			reason := fmt.Sprintf("expected %d elements, got %d", t.Len(), len(arr))
+			return reflect.Value{}, convErr(arr, t, reason)
+		}
+		out = reflect.New(t).Elem()
+	} else {
+		out = reflect.MakeSlice(t, len(arr), len(arr))
+	}
+	for i, elem := range arr {
+		v, err := convertArg(elem, t.Elem())
+		if err != nil {
+			return reflect.Value{}, prefixed(fmt.Sprintf("[%d]", i), err)
+		}
+		out.Index(i).Set(v)
+	}
+	return out, nil
+}
+
+func convertMap(obj map[string]interface{}, t reflect.Type) (reflect.Value, *conversionError) {
+	out := reflect.MakeMapWithSize(t, len(obj))
+	for k, elem := range obj {
+		key, err := convertKey(k, t.Key())
+		if err != nil {
+			return reflect.Value{}, prefixed(fmt.Sprintf("[%q]", k), err)
+		}
+		v, err := convertArg(elem, t.Elem())
+		if err != nil {
+			return reflect.Value{}, prefixed(fmt.Sprintf("[%q]", k), err)
+		}
+		out.SetMapIndex(key, v)
+	}
+	return out, nil
+}
+
+// convertKey converts the key of a JSON object into the key type of a
+// map.  Keys can be strings, or numbers written as strings.
+func convertKey(k string, t reflect.Type) (reflect.Value, *conversionError) {
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(k).Convert(t), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(k, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, convErr(k, t, "invalid key")
+		}
+		return reflect.ValueOf(n).Convert(t), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(k, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, convErr(k, t, "invalid key")
+		}
+		return reflect.ValueOf(n).Convert(t), nil
+	}
+	return reflect.Value{}, convErr(k, t, "unsupported key type")
+}
+
+// convertStruct fills in the fields of a struct from a JSON object.
+// Fields are matched by their json tag, or by their name without
+// regard to case.  Object members that don't match any exported field
+// are an error, so that typos don't go unnoticed.
+func convertStruct(obj map[string]interface{}, t reflect.Type) (reflect.Value, *conversionError) {
+	out := reflect.New(t).Elem()
+	for k, elem := range obj {
+		i, ok := findField(t, k)
+		if !ok {
+			return reflect.Value{}, &conversionError{
+				path: "." + k,
+				msg:  fmt.Sprintf("%v has no field %q", t, k),
+			}
+		}
+		f := t.Field(i)
+		v, err := convertArg(elem, f.Type)
+		if err != nil {
+			return reflect.Value{}, prefixed("."+f.Name, err)
+		}
+		out.Field(i).Set(v)
+	}
+	return out, nil
+}
+
+func findField(t reflect.Type, name string) (int, bool) {
+	match := -1
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag == name || (tag == "" && f.Name == name) {
+			return i, true
+		}
+		if match < 0 && strings.EqualFold(f.Name, name) {
+			match = i
+		}
+	}
+	return match, match >= 0
+}
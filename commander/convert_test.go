@@ -0,0 +1,124 @@
+package commander
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type point struct {
+	X, Y int
+}
+
+type spawn struct {
+	Name  string `json:"name"`
+	Pos   point
+	Tags  []string
+	Delay time.Duration
+}
+
+// decode turns a JSON string into the same kind of value that arrives
+// as an argument from a client.
+func decode(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestConvertArg(t *testing.T) {
+	cases := []struct {
+		in     string
+		target interface{}
+		out    interface{}
+	}{
+		{`3`, int(0), int(3)},
+		{`-3`, int8(0), int8(-3)},
+		{`255`, uint8(0), uint8(255)},
+		{`9007199254740992`, int64(0), int64(9007199254740992)},
+		{`1.5`, float32(0), float32(1.5)},
+		{`"hello"`, "", "hello"},
+		{`true`, false, true},
+		{`"1m30s"`, time.Duration(0), 90 * time.Second},
+		{`[1, 2, 3]`, []int{}, []int{1, 2, 3}},
+		{`[1, 2]`, [2]uint16{}, [2]uint16{1, 2}},
+		{`[[1], [2, 3]]`, [][]int{}, [][]int{{1}, {2, 3}}},
+		{`{"a": "b"}`, map[string]string{}, map[string]string{"a": "b"}},
+		{`{"7": 1.5}`, map[int]float64{}, map[int]float64{7: 1.5}},
+		{`{"x": 1, "Y": 2}`, point{}, point{1, 2}},
+		{`{"x": 1}`, &point{}, &point{X: 1}},
+		{`null`, &point{}, (*point)(nil)},
+		{`null`, []int{}, []int(nil)},
+		{`{"name": "a", "pos": {"x": 1, "y": 2}, "Tags": ["t"], "Delay": "2s"}`,
+			spawn{}, spawn{"a", point{1, 2}, []string{"t"}, 2 * time.Second}},
+		{`{"a": 1}`, map[string]interface{}{}, map[string]interface{}{"a": 1.0}},
+	}
+	for _, c := range cases {
+		target := reflect.TypeOf(c.target)
+		v, err := convertArg(decode(c.in), target)
+		if err != nil {
+			t.Errorf("%s as %v: unexpected error: %v", c.in, target, err)
+			continue
+		}
+		if !reflect.DeepEqual(v.Interface(), c.out) {
+			t.Errorf("%s as %v: expected %#v, got %#v", c.in, target, c.out, v.Interface())
+		}
+	}
+}
+
+func TestConvertArgErrors(t *testing.T) {
+	cases := []struct {
+		in     string
+		target interface{}
+		err    string
+	}{
+		{`1.5`, int(0), `: cannot use 1.5 (float64) as int: not a whole number`},
+		{`256`, uint8(0), `: cannot use 256 (float64) as uint8: out of range`},
+		{`-1`, uint(0), `: cannot use -1 (float64) as uint: out of range`},
+		{`1e300`, float32(0), `: cannot use 1e+300 (float64) as float32: out of range`},
+		{`"5"`, int(0), `: cannot use "5" (string) as int`},
+		{`5`, "", `: cannot use 5 (float64) as string`},
+		{`null`, int(0), `: cannot use null as int`},
+		{`"soon"`, time.Duration(0), `: cannot use "soon" (string) as time.Duration: invalid duration`},
+		{`[1, "2"]`, []int{}, `[1]: cannot use "2" (string) as int`},
+		{`[1, 2, 3]`, [2]int{}, `: cannot use array as [2]int: expected 2 elements, got 3`},
+		{`{"a": 1}`, map[string]string{}, `["a"]: cannot use 1 (float64) as string`},
+		{`{"x": "1"}`, point{}, `.X: cannot use "1" (string) as int`},
+		{`{"z": 1}`, point{}, `.z: commander.point has no field "z"`},
+		{`{"pos": {"x": 0.5}}`, spawn{}, `.Pos.X: cannot use 0.5 (float64) as int: not a whole number`},
+	}
+	for _, c := range cases {
+		target := reflect.TypeOf(c.target)
+		_, err := convertArg(decode(c.in), target)
+		if err == nil {
+			t.Errorf("%s as %v: expected an error", c.in, target)
+			continue
+		}
+		if err.Error() != c.err {
+			t.Errorf("%s as %v:\n got: %s\nwant: %s", c.in, target, err, c.err)
+		}
+	}
+}
+
+func moveTo(name string, p point, speed uint8) string {
+	return fmt.Sprint(name, p, speed)
+}
+
+func TestCallConvertsArguments(t *testing.T) {
+	c := Center{FuncMap: map[string]interface{}{"moveTo": moveTo}}
+
+	result, err := c.CallWithFunctionString(`moveTo("bob", {"X": 3, "Y": 4}, 2)`)
+	if err != nil || result != "bob{3 4} 2" {
+		t.Errorf("expected bob{3 4} 2, got %v, %v", result, err)
+	}
+
+	_, err = c.CallWithFunctionString(`moveTo("bob", {"X": 3, "Y": 4}, 300)`)
+	expected := "Caller: ParameterTypeError: moveTo; Argument 3: " +
+		"cannot use 300 (float64) as uint8: out of range;"
+	if fmt.Sprint(err) != expected {
+		t.Errorf("\n got: %v\nwant: %s", err, expected)
+	}
+}
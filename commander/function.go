@@ -0,0 +1,294 @@
+package commander
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Function is a function along with the details of how it can be
+// called.  A *Function can be placed in the FuncMap of a Center instead
+// of a bare function.
+//
+// Defaults are the values of the trailing parameters, which makes those
+// parameters optional.  For example, the defaults [10, "north"] for
+//
+//	func(name string, steps int, direction string)
+//
+// allow it to be called as f("bob"), f("bob", 3) or f("bob", 3, "west").
+// The defaults never include the variadic parameter of a variadic
+// function, since it is already optional.
+//
+// Params are the names of the parameters, not counting a leading
+// context.Context.  They are only needed for calling the function with
+// named arguments, like f(name="bob", direction="west"), and the name
+// of the variadic parameter can be left out.
+//
+// Timeout overrides the Timeout of the Center for this function.  A
+// negative Timeout means that the function may take as long as it
+// likes.
+//
+// The rest of the fields only describe the function, for the help
+// message and the OpenRPC document.  ParamDocs line up with the
+// parameters the same way as Params.  Tags are free-form labels, like
+// the permissions that are needed to call the function.
+type Function struct {
+	Func     interface{}
+	Defaults []interface{}
+	Params   []string
+	Timeout  time.Duration
+
+	Description string
+	ParamDocs   []string
+	Tags        []string
+}
+
+// WithDefaults wraps a function so that its trailing parameters are
+// optional.  See Function for details.
+func WithDefaults(f interface{}, defaults ...interface{}) *Function {
+	return &Function{Func: f, Defaults: defaults}
+}
+
+// WithParams wraps a function so that it can be called with named
+// arguments.  See Function for details.
+func WithParams(f interface{}, params ...string) *Function {
+	return &Function{Func: f, Params: params}
+}
+
+// asFunction converts an entry of a FuncMap into a *Function.
+func asFunction(v interface{}) *Function {
+	switch f := v.(type) {
+	case *Function:
+		return f
+	case Function:
+		return &f
+	}
+	return &Function{Func: v}
+}
+
+// signature describes the parameters of a function that are filled in
+// by the caller's arguments.
+type signature struct {
+	fn       reflect.Value
+	t        reflect.Type
+	context  bool           // the first parameter is a context.Context.
+	params   []reflect.Type // fixed parameters, after the context.
+	variadic reflect.Type   // element type of the variadic parameter.
+}
+
+func (f *Function) signature(name string) (*signature, error) {
+	t := reflect.TypeOf(f.Func)
+	if t == nil || t.Kind() != reflect.Func {
+		return nil, fmt.Errorf(errNotFunction, name)
+	}
+	s := &signature{fn: reflect.ValueOf(f.Func), t: t}
+	first, last := 0, t.NumIn()
+	if last > 0 && t.In(0) == contextType {
+		s.context = true
+		first = 1
+	}
+	if t.IsVariadic() {
+		last--
+		s.variadic = t.In(last).Elem()
+	}
+	for i := first; i < last; i++ {
+		s.params = append(s.params, t.In(i))
+	}
+	if len(f.Defaults) > len(s.params) {
+		return nil, fmt.Errorf("The command %v has %d defaults, but only %d parameters.",
+			name, len(f.Defaults), len(s.params))
+	}
+	n := len(f.Params)
+	if n != 0 && n != len(s.params) && !(s.variadic != nil && n == len(s.params)+1) {
+		return nil, fmt.Errorf("The command %v has %d parameter names, but %d parameters.",
+			name, n, len(s.params))
+	}
+	return s, nil
+}
+
+// bind converts the arguments into the values passed to the function.
+// Named arguments are first put into place, then missing trailing
+// arguments are filled in from the defaults, and any extra arguments
+// are passed to the variadic parameter.
+func (f *Function) bind(name string, s *signature, args []interface{}, named map[string]interface{}) ([]reflect.Value, error) {
+	if len(named) > 0 {
+		var err error
+		if args, err = f.placeNamed(name, s, args, named); err != nil {
+			return nil, err
+		}
+	}
+
+	required := len(s.params) - len(f.Defaults)
+	if len(args) < required || (s.variadic == nil && len(args) > len(s.params)) {
+		return nil, errArity(name, args, s)
+	}
+
+	var vals []reflect.Value
+	for i, t := range s.params {
+		if i >= len(args) || args[i] == missing {
+			v, err := convertArg(f.Defaults[i-required], t)
+			if err != nil {
+				return nil, fmt.Errorf("The command %v has an invalid default for argument %d%s.",
+					name, i+1, err)
+			}
+			vals = append(vals, v)
+			continue
+		}
+		v, err := convertArg(args[i], t)
+		if err != nil {
+			return nil, errArgument(name, i, f.label(i, err))
+		}
+		vals = append(vals, v)
+	}
+	for i := len(s.params); i < len(args); i++ {
+		v, err := convertArg(args[i], s.variadic)
+		if err != nil {
+			return nil, errArgument(name, i, f.label(len(s.params), err))
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// missing marks a parameter that wasn't given by position or by name,
+// so that bind knows to use its default.
+type missingArg struct{}
+
+var missing interface{} = missingArg{}
+
+// placeNamed merges the named arguments into the positional ones, in
+// the order of the parameters.  A parameter with a default can be
+// skipped, and the variadic parameter is given by name as an array.
+func (f *Function) placeNamed(name string, s *signature, args []interface{}, named map[string]interface{}) ([]interface{}, error) {
+	if len(f.Params) == 0 {
+		return nil, &ArgumentError{
+			Command: name,
+			Message: fmt.Sprintf("The command %v does not accept named arguments.", name),
+		}
+	}
+	if s.variadic == nil && len(args) > len(s.params) {
+		return nil, errArity(name, args, s)
+	}
+
+	placed := make([]interface{}, len(s.params))
+	for i := range placed {
+		placed[i] = missing
+	}
+	copy(placed, args)
+	var rest []interface{}
+	if len(args) > len(s.params) {
+		rest = args[len(s.params):]
+	}
+
+	keys := make([]string, 0, len(named))
+	for k := range named {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		i := f.paramIndex(k)
+		switch {
+		case i < 0:
+			return nil, errNamed(name, "unknown argument %q", k)
+		case i < len(s.params) && i < len(args), i == len(s.params) && rest != nil:
+			return nil, errNamed(name, "argument %q given twice", k)
+		case i < len(s.params):
+			placed[i] = named[k]
+		default:
+			arr, ok := named[k].([]interface{})
+			if !ok {
+				return nil, errNamed(name, "argument %q must be an array", k)
+			}
+			rest = arr
+		}
+	}
+
+	required := len(s.params) - len(f.Defaults)
+	for i := 0; i < required; i++ {
+		if placed[i] == missing {
+			return nil, errNamed(name, "missing argument %q", f.Params[i])
+		}
+	}
+	return append(placed, rest...), nil
+}
+
+func (f *Function) paramIndex(param string) int {
+	for i, p := range f.Params {
+		if p == param {
+			return i
+		}
+	}
+	return -1
+}
+
+// label adds the name of a parameter to a conversion error, when the
+// function has names for its parameters.
+func (f *Function) label(i int, err *conversionError) *conversionError {
+	if i < len(f.Params) {
+		return prefixed(" ("+f.Params[i]+")", err)
+	}
+	return err
+}
+
+func errNamed(name, format string, param string) error {
+	return &ArgumentError{
+		Command: name,
+		Message: fmt.Sprintf("ParameterError: %v; %s;", name, fmt.Sprintf(format, param)),
+	}
+}
+
+// usage describes the parameters and results of the function, like
+// "(name string, x int) error".  The parameters are only named when
+// the function has Params.
+func (f *Function) usage() string {
+	t := reflect.TypeOf(f.Func)
+	if t == nil || t.Kind() != reflect.Func {
+		return fmt.Sprint(" ", t)
+	}
+	if len(f.Params) == 0 {
+		return strings.TrimPrefix(t.String(), "func")
+	}
+	first := 0
+	if t.NumIn() > 0 && t.In(0) == contextType {
+		first = 1
+	}
+	var params []string
+	for i := first; i < t.NumIn(); i++ {
+		p := t.In(i).String()
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			p = "..." + t.In(i).Elem().String()
+		}
+		if i-first < len(f.Params) {
+			p = f.Params[i-first] + " " + p
+		}
+		params = append(params, p)
+	}
+	var results []string
+	for i := 0; i < t.NumOut(); i++ {
+		results = append(results, t.Out(i).String())
+	}
+	s := "(" + strings.Join(params, ", ") + ")"
+	switch len(results) {
+	case 0:
+		return s
+	case 1:
+		return s + " " + results[0]
+	}
+	return s + " (" + strings.Join(results, ", ") + ")"
+}
+
+// errArity is the error for calling a function with the wrong number of
+// arguments.  It lists the types of the arguments and parameters.
+func errArity(name string, args []interface{}, s *signature) error {
+	var argTypes, paramTypes []reflect.Type
+	for _, v := range args {
+		argTypes = append(argTypes, reflect.TypeOf(v))
+	}
+	paramTypes = append(paramTypes, s.params...)
+	if s.variadic != nil {
+		paramTypes = append(paramTypes, reflect.SliceOf(s.variadic))
+	}
+	return errTypes(name, argTypes, paramTypes)
+}
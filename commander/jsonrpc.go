@@ -0,0 +1,322 @@
+package commander
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// JSON-RPC 2.0 error codes.  The codes from -32768 to -32000 are
+// reserved by the specification (https://www.jsonrpc.org/specification).
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+
+	// CodeCommandError is used when a command returns an error of its
+	// own, like "player does not exist."
+	CodeCommandError = -32000
+)
+
+const jsonrpcVersion = "2.0"
+
+// RPCRequest is a single JSON-RPC 2.0 request.  A request without an
+// ID is a notification, which is called but never answered.
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// RPCResponse is a successful JSON-RPC 2.0 response.  It is left for
+// the caller to encode, so that the Result can be written in any
+// encoding, not only JSON.  The ID is the id of the request, as it was
+// decoded.
+type RPCResponse struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Result  interface{} `json:"result"`
+	ID      interface{} `json:"id"`
+}
+
+// RPCErrorResponse is a JSON-RPC 2.0 response for a request that
+// failed.  It is a type of its own, since a response can't have both a
+// result and an error, even a result of null.
+type RPCErrorResponse struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Error   *RPCError   `json:"error"`
+	ID      interface{} `json:"id"`
+}
+
+// RPCError is the error object of a JSON-RPC 2.0 response.
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// nullID is the id of a response to a request that couldn't be read.
+var nullID interface{}
+
+// IsJSONRPC reports whether a message should be handled as JSON-RPC,
+// instead of as a Command or a function string.  Batches (arrays) and
+// objects with a "jsonrpc" member are JSON-RPC, and so is anything
+// that starts like JSON but can't be parsed, so that the sender gets a
+// proper parse error.
+func IsJSONRPC(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return false
+	}
+	switch b[0] {
+	case '[':
+		return true
+	case '{':
+		var probe struct {
+			JSONRPC *string `json:"jsonrpc"`
+		}
+		if err := json.Unmarshal(b, &probe); err != nil {
+			return !json.Valid(b)
+		}
+		return probe.JSONRPC != nil
+	}
+	return false
+}
+
+// HandleJSONRPC calls the commands in a JSON-RPC 2.0 message, which is
+// either a single request or a batch of requests, and returns the
+// response: an *RPCResponse or an *RPCErrorResponse, or a slice of
+// them for a batch.  When the message only contains notifications,
+// there is nothing to respond with, so nil is returned.  The context is
+// passed along to functions that accept one.
+func (c *Center) HandleJSONRPC(ctx context.Context, b []byte) interface{} {
+	b = bytes.TrimSpace(b)
+	if !json.Valid(b) {
+		return errorResponse(nullID, CodeParseError, "Parse error")
+	}
+
+	// a single request.
+	if len(b) == 0 || b[0] != '[' {
+		return c.handleRPCRequest(ctx, b)
+	}
+
+	// a batch of requests.
+	var batch []json.RawMessage
+	if err := json.Unmarshal(b, &batch); err != nil || len(batch) == 0 {
+		return errorResponse(nullID, CodeInvalidRequest, "Invalid Request")
+	}
+	out := make([]interface{}, 0, len(batch))
+	for _, req := range batch {
+		if r := c.handleRPCRequest(ctx, req); r != nil {
+			out = append(out, r)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// HandleRPC is the same as HandleJSONRPC, but for a message that has
+// already been decoded, like one read with a binary codec.  A request
+// is a map[string]interface{}, and a batch is a []interface{} of them.
+func (c *Center) HandleRPC(ctx context.Context, v interface{}) interface{} {
+	batch, ok := v.([]interface{})
+	if !ok {
+		return c.handleRPCValue(ctx, v)
+	}
+	if len(batch) == 0 {
+		return errorResponse(nullID, CodeInvalidRequest, "Invalid Request")
+	}
+	out := make([]interface{}, 0, len(batch))
+	for _, req := range batch {
+		if r := c.handleRPCValue(ctx, req); r != nil {
+			out = append(out, r)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// handleRPCRequest calls a single request.  It returns nil for
+// notifications.
+func (c *Center) handleRPCRequest(ctx context.Context, b json.RawMessage) interface{} {
+	var req RPCRequest
+	err := json.Unmarshal(b, &req)
+	if err != nil || req.JSONRPC != jsonrpcVersion || req.Method == "" {
+		return errorResponse(requestID(b), CodeInvalidRequest, "Invalid Request")
+	}
+	if req.ID != nil && !usableID(req.ID) {
+		return errorResponse(nullID, CodeInvalidRequest, "Invalid Request")
+	}
+	args, named, rpcErr := decodeParams(req.Params)
+
+	// notifications are called, but never answered, even when
+	// something goes wrong.
+	var id interface{}
+	if req.ID != nil {
+		id = req.ID
+	}
+	return c.answerRPC(ctx, req.Method, args, named, rpcErr, id, req.ID != nil)
+}
+
+// handleRPCValue calls a single request that has already been decoded.
+// It returns nil for notifications.
+func (c *Center) handleRPCValue(ctx context.Context, v interface{}) interface{} {
+	req, ok := v.(map[string]interface{})
+	if !ok {
+		return errorResponse(nullID, CodeInvalidRequest, "Invalid Request")
+	}
+	method, _ := req["method"].(string)
+	id, hasID := req["id"]
+	if req["jsonrpc"] != jsonrpcVersion || method == "" || (id != nil && valueID(id) == nullID) {
+		return errorResponse(valueID(id), CodeInvalidRequest, "Invalid Request")
+	}
+
+	var args []interface{}
+	var named map[string]interface{}
+	var rpcErr *RPCError
+	switch params := req["params"].(type) {
+	case nil:
+	case []interface{}:
+		args = params
+	case map[string]interface{}:
+		named = params
+	default:
+		rpcErr = &RPCError{
+			Code:    CodeInvalidParams,
+			Message: "Invalid params",
+			Data:    "params must be an array or an object",
+		}
+	}
+	return c.answerRPC(ctx, method, args, named, rpcErr, id, hasID)
+}
+
+// answerRPC calls a request, unless its params were already found to
+// be invalid, and builds the response.  Notifications, which have no
+// id, are called but never answered.
+func (c *Center) answerRPC(ctx context.Context, method string, args []interface{}, named map[string]interface{}, rpcErr *RPCError, id interface{}, hasID bool) interface{} {
+	var result interface{}
+	if rpcErr == nil {
+		result, rpcErr = c.callRPC(ctx, method, args, named)
+	}
+	if !hasID {
+		return nil
+	}
+	if rpcErr != nil {
+		return &RPCErrorResponse{JSONRPC: jsonrpcVersion, Error: rpcErr, ID: id}
+	}
+	return &RPCResponse{JSONRPC: jsonrpcVersion, Result: result, ID: id}
+}
+
+// callRPC calls a command, and translates its errors into JSON-RPC
+// error objects.
+func (c *Center) callRPC(ctx context.Context, method string, args []interface{}, named map[string]interface{}) (interface{}, *RPCError) {
+	result, err := c.CallNamed(ctx, method, args, named)
+	var notFound *NotFoundError
+	var badArgs *ArgumentError
+	var panicked *PanicError
+	switch {
+	case errors.As(err, &notFound):
+		return nil, &RPCError{Code: CodeMethodNotFound, Message: "Method not found", Data: err.Error()}
+	case errors.As(err, &badArgs):
+		return nil, &RPCError{Code: CodeInvalidParams, Message: "Invalid params", Data: err.Error()}
+	case errors.As(err, &panicked):
+		return nil, &RPCError{Code: CodeInternalError, Message: "Internal error", Data: err.Error()}
+	case err != nil:
+		return nil, &RPCError{Code: CodeCommandError, Message: err.Error()}
+	}
+	return result, nil
+}
+
+// decodeParams converts the params of a request into arguments.  An
+// array gives positional arguments, and an object gives named
+// arguments.
+func decodeParams(params json.RawMessage) ([]interface{}, map[string]interface{}, *RPCError) {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 {
+		return nil, nil, nil
+	}
+	var args []interface{}
+	var named map[string]interface{}
+	var err error
+	switch params[0] {
+	case '[':
+		err = json.Unmarshal(params, &args)
+	case '{':
+		err = json.Unmarshal(params, &named)
+	default:
+		return nil, nil, &RPCError{
+			Code:    CodeInvalidParams,
+			Message: "Invalid params",
+			Data:    "params must be an array or an object",
+		}
+	}
+	if err != nil {
+		return nil, nil, &RPCError{Code: CodeInvalidParams, Message: "Invalid params", Data: err.Error()}
+	}
+	return args, named, nil
+}
+
+// requestID digs the id out of a request that couldn't be decoded, so
+// that the error can still be matched to it.  If there is no usable
+// id, it is null.
+func requestID(b json.RawMessage) interface{} {
+	var probe struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(b, &probe) != nil || probe.ID == nil || !usableID(probe.ID) {
+		return nullID
+	}
+	return probe.ID
+}
+
+// usableID checks that an id is a string, a number or null, which are
+// the only ids that JSON-RPC allows.
+func usableID(id json.RawMessage) bool {
+	id = bytes.TrimSpace(id)
+	if len(id) == 0 {
+		return false
+	}
+	switch id[0] {
+	case '"', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'n':
+		return true
+	}
+	return false
+}
+
+// valueID is the same as requestID, for an id that has already been
+// decoded.  Strings and numbers are the only usable ids.
+func valueID(id interface{}) interface{} {
+	switch id.(type) {
+	case string, float32, float64,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64:
+		return id
+	}
+	return nullID
+}
+
+// RPCInternalError is the response to send in place of one that
+// couldn't be encoded.
+func RPCInternalError(err error) *RPCErrorResponse {
+	return errorResponse(nullID, CodeInternalError, err.Error())
+}
+
+func errorResponse(id interface{}, code int, message string) *RPCErrorResponse {
+	return &RPCErrorResponse{
+		JSONRPC: jsonrpcVersion,
+		Error:   &RPCError{Code: code, Message: message},
+		ID:      id,
+	}
+}
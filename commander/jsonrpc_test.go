@@ -0,0 +1,201 @@
+package commander
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/tilegame/gameserver/codec"
+)
+
+// these cases are adapted from the examples in the JSON-RPC 2.0
+// specification.
+func TestHandleJSONRPC(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{"positional params",
+			`{"jsonrpc": "2.0", "method": "multInt", "params": [6, 7], "id": 1}`,
+			`{"jsonrpc": "2.0", "result": 42, "id": 1}`},
+		{"string id",
+			`{"jsonrpc": "2.0", "method": "GimmeTrue", "id": "abc"}`,
+			`{"jsonrpc": "2.0", "result": true, "id": "abc"}`},
+		{"null result",
+			`{"jsonrpc": "2.0", "method": "checkPositive", "params": [1], "id": 2}`,
+			`{"jsonrpc": "2.0", "result": null, "id": 2}`},
+		{"null id",
+			`{"jsonrpc": "2.0", "method": "GimmeTrue", "id": null}`,
+			`{"jsonrpc": "2.0", "result": true, "id": null}`},
+		{"method not found",
+			`{"jsonrpc": "2.0", "method": "foobar", "id": "1"}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found",
+			  "data": "Command foobar Not Found."}, "id": "1"}`},
+		{"invalid params",
+			`{"jsonrpc": "2.0", "method": "multInt", "params": [6], "id": 3}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32602, "message": "Invalid params",
+			  "data": "ParameterTypeError: multInt; Got: [float64]; Expected: [int int];"}, "id": 3}`},
+		{"named params",
+			`{"jsonrpc": "2.0", "method": "Add", "params": {"a": 1, "b": 2}, "id": 7}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32602, "message": "Invalid params",
+			  "data": "The command Add does not accept named arguments."}, "id": 7}`},
+		{"string params",
+			`{"jsonrpc": "2.0", "method": "Add", "params": "1, 2", "id": 8}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32602, "message": "Invalid params",
+			  "data": "params must be an array or an object"}, "id": 8}`},
+		{"command error",
+			`{"jsonrpc": "2.0", "method": "checkPositive", "params": [-1], "id": 4}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32000, "message": "-1 is not positive"}, "id": 4}`},
+		{"parse error",
+			`{"jsonrpc": "2.0", "method": "foobar, "params": "bar", "baz]`,
+			`{"jsonrpc": "2.0", "error": {"code": -32700, "message": "Parse error"}, "id": null}`},
+		{"invalid request",
+			`{"jsonrpc": "2.0", "method": 1, "params": "bar", "id": 5}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": 5}`},
+		{"wrong version",
+			`{"jsonrpc": "1.0", "method": "GimmeTrue", "id": 6}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": 6}`},
+		{"object id",
+			`{"jsonrpc": "2.0", "method": "GimmeTrue", "id": {"a": 1}}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`},
+		{"array id",
+			`{"jsonrpc": "2.0", "method": "GimmeTrue", "id": [1]}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`},
+		{"empty batch",
+			`[]`,
+			`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`},
+		{"invalid batch",
+			`[1, 2]`,
+			`[{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null},
+			  {"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}]`},
+		{"batch",
+			`[{"jsonrpc": "2.0", "method": "Add", "params": [1, 2], "id": "1"},
+			  {"jsonrpc": "2.0", "method": "Add", "params": [7, 7]},
+			  {"foo": "boo"},
+			  {"jsonrpc": "2.0", "method": "get_data", "id": "9"}]`,
+			`[{"jsonrpc": "2.0", "result": 3, "id": "1"},
+			  {"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null},
+			  {"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found",
+			   "data": "Command get_data Not Found."}, "id": "9"}]`},
+	}
+	for _, c := range cases {
+		got, _ := json.Marshal(center.HandleJSONRPC(context.Background(), []byte(c.in)))
+		var gotVal, wantVal interface{}
+		if err := json.Unmarshal(got, &gotVal); err != nil {
+			t.Errorf("%s: invalid response %s: %v", c.name, got, err)
+			continue
+		}
+		json.Unmarshal([]byte(c.out), &wantVal)
+		if !reflect.DeepEqual(gotVal, wantVal) {
+			t.Errorf("%s:\n got: %s\nwant: %s", c.name, got, c.out)
+		}
+	}
+}
+
+func TestJSONRPCNotifications(t *testing.T) {
+	cases := []string{
+		`{"jsonrpc": "2.0", "method": "Add", "params": [1, 2]}`,
+		`{"jsonrpc": "2.0", "method": "foobar"}`,
+		`[{"jsonrpc": "2.0", "method": "Add", "params": [1, 2]},
+		  {"jsonrpc": "2.0", "method": "GimmeTrue"}]`,
+	}
+	for _, c := range cases {
+		if got := center.HandleJSONRPC(context.Background(), []byte(c)); got != nil {
+			t.Errorf("%s: expected no response, got %v", c, got)
+		}
+	}
+}
+
+// TestHandleValue sends messages in each of the codecs, the way that a
+// websocket client would, and checks the answers.
+func TestHandleValue(t *testing.T) {
+	type obj = map[string]interface{}
+	type arr = []interface{}
+	cases := []struct {
+		name string
+		in   interface{}
+		out  string
+	}{
+		{"rpc",
+			obj{"jsonrpc": "2.0", "method": "multInt", "params": arr{6, 7}, "id": 1},
+			`{"jsonrpc": "2.0", "result": 42, "id": 1}`},
+		{"null result",
+			obj{"jsonrpc": "2.0", "method": "checkPositive", "params": arr{1}, "id": "a"},
+			`{"jsonrpc": "2.0", "result": null, "id": "a"}`},
+		{"method not found",
+			obj{"jsonrpc": "2.0", "method": "foobar", "id": 2},
+			`{"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found",
+			  "data": "Command foobar Not Found."}, "id": 2}`},
+		{"invalid request",
+			obj{"jsonrpc": "1.0", "method": "Add", "id": true},
+			`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`},
+		{"array id",
+			obj{"jsonrpc": "2.0", "method": "Add", "params": arr{1, 2}, "id": arr{1}},
+			`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`},
+		{"batch",
+			arr{
+				obj{"jsonrpc": "2.0", "method": "Add", "params": arr{1, 2}, "id": 3},
+				obj{"jsonrpc": "2.0", "method": "Add", "params": arr{7, 7}},
+			},
+			`[{"jsonrpc": "2.0", "result": 3, "id": 3}]`},
+		{"notification",
+			obj{"jsonrpc": "2.0", "method": "GimmeTrue"},
+			`null`},
+		{"command",
+			obj{"Name": "Add", "Args": arr{1, 2}},
+			`{"Result": 3, "Error": null}`},
+		{"function syntax",
+			"Add(1, 2)",
+			`{"Result": 3, "Error": null}`},
+		{"bad command",
+			obj{"Name": 5},
+			`{"Result": null, "Error": "invalid command: Name must be a string."}`},
+	}
+	for _, cd := range codec.Codecs {
+		for _, c := range cases {
+			b, err := cd.Marshal(c.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var in interface{}
+			if err := cd.Unmarshal(b, &in); err != nil {
+				t.Fatal(err)
+			}
+			b, err = cd.Marshal(center.HandleValue(context.Background(), in))
+			if err != nil {
+				t.Errorf("%s: %s: %v", cd.Name(), c.name, err)
+				continue
+			}
+			var out interface{}
+			cd.Unmarshal(b, &out)
+			got, _ := json.Marshal(out)
+
+			var gotVal, wantVal interface{}
+			json.Unmarshal(got, &gotVal)
+			json.Unmarshal([]byte(c.out), &wantVal)
+			if !reflect.DeepEqual(gotVal, wantVal) {
+				t.Errorf("%s: %s:\n got: %s\nwant: %s", cd.Name(), c.name, got, c.out)
+			}
+		}
+	}
+}
+
+func TestIsJSONRPC(t *testing.T) {
+	cases := []struct {
+		in string
+		ok bool
+	}{
+		{`{"jsonrpc": "2.0", "method": "Add"}`, true},
+		{`[{"jsonrpc": "2.0", "method": "Add"}]`, true},
+		{`{"Name": "Add", "Args": [1, 2]}`, false},
+		{`Add(1, 2)`, false},
+		{`{"jsonrpc": "2.0", "method`, true},
+	}
+	for _, c := range cases {
+		if got := IsJSONRPC([]byte(c.in)); got != c.ok {
+			t.Errorf("IsJSONRPC(%s) = %v, expected %v", c.in, got, c.ok)
+		}
+	}
+}
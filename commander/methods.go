@@ -0,0 +1,119 @@
+package commander
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// MethodOptions chooses which methods RegisterMethods adds, and how
+// they are described.  Methods are always given by their Go names,
+// like "MovePlayer".
+//
+// When Include is empty, every exported method is added, except for
+// the ones in Exclude.  Functions holds the details of some of the
+// methods, like their parameter names and descriptions; the Func of
+// each one is filled in with the method.
+type MethodOptions struct {
+	Include   []string
+	Exclude   []string
+	Functions map[string]*Function
+}
+
+// RegisterMethods adds the exported methods of the receiver as
+// commands.  Each command is named after its method, in the namespace,
+// so that the method MovePlayer in the namespace "game" becomes the
+// command "game.movePlayer".  An empty namespace leaves the names
+// without a prefix.
+//
+// The receiver is usually a pointer, since the methods of a pointer
+// include those of the value it points to.  opts may be nil.
+func (c *Center) RegisterMethods(namespace string, receiver interface{}, opts *MethodOptions) error {
+	if opts == nil {
+		opts = &MethodOptions{}
+	}
+	v := reflect.ValueOf(receiver)
+	if !v.IsValid() {
+		return fmt.Errorf("RegisterMethods: the receiver for %q is nil.", namespace)
+	}
+	t := v.Type()
+
+	// every method that is mentioned has to exist, so that typos
+	// don't silently expose or hide something.
+	for _, list := range [][]string{opts.Include, opts.Exclude, mapKeys(opts.Functions)} {
+		for _, name := range list {
+			if _, ok := t.MethodByName(name); !ok {
+				return fmt.Errorf("RegisterMethods: %v has no method %q.", t, name)
+			}
+		}
+	}
+
+	functions := map[string]*Function{}
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if m.PkgPath != "" || !opts.includes(m.Name) {
+			continue
+		}
+		f := &Function{}
+		if details, ok := opts.Functions[m.Name]; ok {
+			copied := *details
+			f = &copied
+		}
+		f.Func = v.Method(i).Interface()
+		functions[commandName(namespace, m.Name)] = f
+	}
+
+	// check all of the methods before adding any of them.
+	for name, f := range functions {
+		if _, err := f.signature(name); err != nil {
+			return err
+		}
+	}
+	return c.registerAll(functions)
+}
+
+func (o *MethodOptions) includes(method string) bool {
+	for _, name := range o.Exclude {
+		if name == method {
+			return false
+		}
+	}
+	if len(o.Include) == 0 {
+		return true
+	}
+	for _, name := range o.Include {
+		if name == method {
+			return true
+		}
+	}
+	return false
+}
+
+// commandName is the name of the command for a method, like
+// "game.movePlayer" for MovePlayer.  A leading acronym is lowered as a
+// whole, so that HTTPStatus becomes "httpStatus" and ID becomes "id".
+func commandName(namespace, method string) string {
+	r := []rune(method)
+	for i := range r {
+		if !unicode.IsUpper(r[i]) {
+			break
+		}
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	if namespace == "" {
+		return string(r)
+	}
+	return strings.TrimSuffix(namespace, ".") + "." + string(r)
+}
+
+func mapKeys(m map[string]*Function) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
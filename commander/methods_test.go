@@ -0,0 +1,93 @@
+package commander
+
+import (
+	"fmt"
+	"testing"
+)
+
+type counter struct {
+	n int
+}
+
+func (c *counter) Add(n int) int        { c.n += n; return c.n }
+func (c *counter) Reset()               { c.n = 0 }
+func (c counter) Value() int            { return c.n }
+func (c *counter) HTTPStatus() int      { return 200 }
+func (c *counter) ID() string           { return "counter" }
+func (c *counter) secret() int          { return 42 }
+func (c *counter) Scale(by float64) int { c.n = int(float64(c.n) * by); return c.n }
+
+func TestRegisterMethods(t *testing.T) {
+	c := &Center{}
+	cnt := &counter{}
+	err := c.RegisterMethods("counter", cnt, &MethodOptions{
+		Exclude: []string{"Reset"},
+		Functions: map[string]*Function{
+			"Scale": {Params: []string{"by"}, Defaults: []interface{}{2}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[counter.add counter.httpStatus counter.id counter.scale counter.value]"
+	if names := fmt.Sprint(c.Names()); names != expected {
+		t.Errorf("\n got: %s\nwant: %s", names, expected)
+	}
+
+	calls := []struct {
+		in  string
+		out string
+	}{
+		{`counter.add(3)`, "3"},
+		{`counter.add(4)`, "7"},
+		{`counter.scale()`, "14"},
+		{`counter.scale(by=0.5)`, "7"},
+		{`counter.value()`, "7"},
+		{`counter.reset()`, "Caller: Command counter.reset Not Found."},
+		{`counter.secret()`, "Caller: Command counter.secret Not Found."},
+	}
+	for _, tc := range calls {
+		result, err := c.CallWithFunctionString(tc.in)
+		out := fmt.Sprint(result)
+		if err != nil {
+			out = err.Error()
+		}
+		if out != tc.out {
+			t.Errorf("%s: expected %s, got %s", tc.in, tc.out, out)
+		}
+	}
+}
+
+func TestRegisterMethodsOptions(t *testing.T) {
+	c := &Center{}
+	err := c.RegisterMethods("", &counter{}, &MethodOptions{Include: []string{"Add", "Value"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := fmt.Sprint(c.Names()); names != "[add value]" {
+		t.Errorf("expected [add value], got %s", names)
+	}
+
+	errors := []struct {
+		opts *MethodOptions
+		err  string
+	}{
+		{&MethodOptions{Include: []string{"Ad"}},
+			`RegisterMethods: *commander.counter has no method "Ad".`},
+		{&MethodOptions{Exclude: []string{"secret"}},
+			`RegisterMethods: *commander.counter has no method "secret".`},
+		{&MethodOptions{Functions: map[string]*Function{"Add": {Params: []string{"a", "b"}}}},
+			`The command c.add has 2 parameter names, but 1 parameters.`},
+	}
+	for _, tc := range errors {
+		c := &Center{}
+		err := c.RegisterMethods("c", &counter{}, tc.opts)
+		if fmt.Sprint(err) != tc.err {
+			t.Errorf("\n got: %v\nwant: %s", err, tc.err)
+		}
+		if len(c.FuncMap) != 0 {
+			t.Errorf("nothing should be registered after an error, got %v", c.Names())
+		}
+	}
+}
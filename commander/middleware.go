@@ -0,0 +1,95 @@
+package commander
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Request is a single call of a command, as seen by a Middleware.
+// Function is nil when there is no command by the Name.
+//
+// A Middleware can change the Request before passing it along, for
+// example to fill in an argument.
+type Request struct {
+	Name     string
+	Args     []interface{}
+	Named    map[string]interface{}
+	Function *Function
+}
+
+// Handler calls a command.  The innermost Handler of a Center is the
+// one that converts the arguments and calls the function.
+type Handler func(ctx context.Context, req *Request) (interface{}, error)
+
+// Middleware wraps a Handler with some logic of its own, like logging,
+// timing, or checking that the caller is allowed to use the command.
+// It can skip calling next entirely, and return its own result or
+// error instead.
+type Middleware func(next Handler) Handler
+
+// Use adds middleware around every call of a command.  The first
+// middleware given is the outermost, so it runs first and sees the
+// final result.  Middleware added by a later Use runs inside of the
+// middleware that is already there.
+//
+// Each Middleware is only called once, by Use, to make its Handler.
+// Anything that it sets up, like the counts of a rate limiter, is kept
+// for every call after that.
+func (c *Center) Use(mw ...Middleware) {
+	if len(mw) == 0 {
+		return
+	}
+	end := &link{}
+	end.next.Store(Handler(c.call))
+	h := Handler(end.call)
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.end == nil {
+		c.chain = h
+	} else {
+		c.end.next.Store(h)
+	}
+	c.end = end
+}
+
+// handler is the chain of middleware around the call.  The caller holds
+// the lock.
+func (c *Center) handler() Handler {
+	if c.chain == nil {
+		return c.call
+	}
+	return c.chain
+}
+
+// link is the end of the middleware given to a single Use.  It calls
+// the innermost Handler, which is the call itself until more middleware
+// is added.
+type link struct {
+	next atomic.Value // Handler
+}
+
+func (l *link) call(ctx context.Context, req *Request) (interface{}, error) {
+	return l.next.Load().(Handler)(ctx, req)
+}
+
+// Arg returns the argument given for a parameter, either by name or by
+// its position in the Function's Params.  It is false when the argument
+// wasn't given, or there is no Function.
+func (r *Request) Arg(param string) (interface{}, bool) {
+	if v, ok := r.Named[param]; ok {
+		return v, true
+	}
+	if r.Function == nil {
+		return nil, false
+	}
+	for i, p := range r.Function.Params {
+		if p == param && i < len(r.Args) {
+			return r.Args[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,142 @@
+package commander
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+
+	// record shows the order that the middleware runs in.
+	record := func(label string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, req *Request) (interface{}, error) {
+				calls = append(calls, label+" "+req.Name)
+				result, err := next(ctx, req)
+				calls = append(calls, fmt.Sprint(label, " ", result, " ", err))
+				return result, err
+			}
+		}
+	}
+
+	// adminOnly refuses commands tagged "admin".
+	adminOnly := func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (interface{}, error) {
+			if req.Function != nil {
+				for _, tag := range req.Function.Tags {
+					if tag == "admin" {
+						return nil, errors.New("not allowed")
+					}
+				}
+			}
+			return next(ctx, req)
+		}
+	}
+
+	// doubling changes the arguments of add.
+	doubling := func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (interface{}, error) {
+			if req.Name == "add" {
+				req.Args = append(req.Args, req.Args...)
+			}
+			return next(ctx, req)
+		}
+	}
+
+	c := &Center{}
+	c.MustRegister("add", add)
+	c.MustRegister("reset", &Function{Func: gimmeTrue, Tags: []string{"admin"}})
+	c.Use(record("outer"), adminOnly, record("inner"), doubling)
+
+	cases := []struct {
+		call  func() (interface{}, error)
+		out   string
+		calls []string
+	}{
+		{func() (interface{}, error) { return c.Call("add", 1, 2) },
+			"<nil> ParameterTypeError: add; Got: [int int int int]; Expected: [int int];",
+			[]string{"outer add", "inner add",
+				"inner <nil> ParameterTypeError: add; Got: [int int int int]; Expected: [int int];",
+				"outer <nil> ParameterTypeError: add; Got: [int int int int]; Expected: [int int];"}},
+		{func() (interface{}, error) { return c.Call("reset") },
+			"<nil> not allowed",
+			[]string{"outer reset", "outer <nil> not allowed"}},
+		{func() (interface{}, error) { return c.Call("missing") },
+			"<nil> Command missing Not Found.",
+			[]string{"outer missing", "inner missing",
+				"inner <nil> Command missing Not Found.",
+				"outer <nil> Command missing Not Found."}},
+	}
+	for _, tc := range cases {
+		calls = nil
+		result, err := tc.call()
+		if out := fmt.Sprint(result, " ", err); out != tc.out {
+			t.Errorf("\n got: %s\nwant: %s", out, tc.out)
+		}
+		if strings.Join(calls, "\n") != strings.Join(tc.calls, "\n") {
+			t.Errorf("middleware ran as:\n%s\nexpected:\n%s",
+				strings.Join(calls, "\n"), strings.Join(tc.calls, "\n"))
+		}
+	}
+}
+
+// TestMiddlewareState checks that each Middleware is only set up once,
+// so that it can keep count between calls.
+func TestMiddlewareState(t *testing.T) {
+	built := 0
+	limit := func(n int) Middleware {
+		return func(next Handler) Handler {
+			built++
+			calls := 0
+			return func(ctx context.Context, req *Request) (interface{}, error) {
+				if calls++; calls > n {
+					return nil, errors.New("too many calls")
+				}
+				return next(ctx, req)
+			}
+		}
+	}
+
+	c := &Center{}
+	c.MustRegister("true", gimmeTrue)
+	c.Use(limit(3))
+	c.Call("true")
+	c.Use(limit(1))
+	var out []string
+	for i := 0; i < 3; i++ {
+		result, err := c.Call("true")
+		out = append(out, fmt.Sprint(result, " ", err))
+	}
+	want := []string{"true <nil>", "<nil> too many calls", "<nil> too many calls"}
+	if strings.Join(out, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got %v, want %v", out, want)
+	}
+	if built != 2 {
+		t.Errorf("expected the middleware to be set up twice, got %d", built)
+	}
+}
+
+func TestRequestArg(t *testing.T) {
+	f := &Function{Func: add, Params: []string{"a", "b"}}
+	cases := []struct {
+		req   Request
+		param string
+		out   string
+	}{
+		{Request{Args: []interface{}{1, 2}, Function: f}, "b", "2 true"},
+		{Request{Args: []interface{}{1}, Named: map[string]interface{}{"b": 3}, Function: f}, "b", "3 true"},
+		{Request{Args: []interface{}{1}, Function: f}, "b", "<nil> false"},
+		{Request{Args: []interface{}{1, 2}, Function: f}, "c", "<nil> false"},
+		{Request{Args: []interface{}{1, 2}}, "a", "<nil> false"},
+	}
+	for _, tc := range cases {
+		v, ok := tc.req.Arg(tc.param)
+		if out := fmt.Sprint(v, " ", ok); out != tc.out {
+			t.Errorf("Arg(%q) of %v: got %s, want %s", tc.param, tc.req.Args, out, tc.out)
+		}
+	}
+}
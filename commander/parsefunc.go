@@ -1,53 +1,165 @@
 package commander
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
+	"unicode"
 )
 
-func parseFunctionSyntax(s string) (string, []string, error) {
-	var name string
-	var args []string
-	var err error
-	var currentArgument string
+// ParseFunctionString converts a string in the function syntax,
+// functionName(arg1, arg2, ...), into a Command.  The arguments are
+// decoded as JSON values, so strings keep their spaces and commas, and
+// arrays or objects can be nested inside of them.
+//
+// Arguments can also be named, like move(x=3, y=4).  Named arguments
+// must come after all of the positional arguments, and are matched to
+// the parameter names given when the function was registered.
+func ParseFunctionString(s string) (*Command, error) {
+	name, args, named, err := parseFunctionSyntax(s)
+	if err != nil {
+		return nil, fmt.Errorf("Parser: %v", err)
+	}
+	cmd := &Command{Name: name}
+	for _, a := range args {
+		var v interface{}
+		if json.Unmarshal([]byte(a), &v) != nil {
+			return nil, fmt.Errorf("Parser: JSON syntax error in argument %s.", a)
+		}
+		cmd.Args = append(cmd.Args, v)
+	}
+	for _, a := range named {
+		var v interface{}
+		if json.Unmarshal([]byte(a.value), &v) != nil {
+			return nil, fmt.Errorf("Parser: JSON syntax error in argument %s.", a.name)
+		}
+		if cmd.Named == nil {
+			cmd.Named = map[string]interface{}{}
+		}
+		cmd.Named[a.name] = v
+	}
+	return cmd, nil
+}
 
-	s = strings.Replace(s, " ", "", -1)
+type namedArg struct {
+	name  string
+	value string
+}
 
-	for i, r := range s {
-		switch r {
-		case '(':
-			s = s[i+1:]
-			goto parseArgs
-		default:
-			name += string(r)
+// parseFunctionSyntax splits a function string into the name of the
+// function, its positional arguments, and its named arguments.  The
+// arguments are returned as unparsed JSON text.  A single trailing
+// comma after the last argument is allowed.
+func parseFunctionSyntax(s string) (string, []string, []namedArg, error) {
+	open := strings.IndexRune(s, '(')
+	if open < 0 {
+		return "", nil, nil, fmt.Errorf("syntax error: '(' not found.")
+	}
+	name := strings.TrimSpace(s[:open])
+
+	raw, rest, err := splitArguments(s[open+1:])
+	if err != nil {
+		return name, nil, nil, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return name, nil, nil, fmt.Errorf("syntax error: extra characters found after ')'.")
+	}
+
+	var args []string
+	var named []namedArg
+	seen := map[string]bool{}
+	for i, a := range raw {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			if i == len(raw)-1 && i > 0 {
+				// trailing comma.
+				break
+			}
+			if len(raw) == 1 {
+				// no arguments at all.
+				break
+			}
+			return name, nil, nil, fmt.Errorf("syntax error: empty argument %d.", i+1)
 		}
+		if key, value, ok := splitNamed(a); ok {
+			if seen[key] {
+				return name, nil, nil, fmt.Errorf("syntax error: argument %s given twice.", key)
+			}
+			seen[key] = true
+			named = append(named, namedArg{key, value})
+			continue
+		}
+		if len(named) > 0 {
+			return name, nil, nil, fmt.Errorf(
+				"syntax error: positional argument %d after named arguments.", i+1)
+		}
+		args = append(args, a)
 	}
-	err = fmt.Errorf("syntax error: '(' not found.")
-	goto ret
-parseArgs:
-	currentArgument = ""
+	return name, args, named, nil
+}
+
+// splitArguments scans the text after the opening '(', and splits it
+// on the commas that separate the arguments.  Commas inside of strings,
+// arrays and objects are left alone.  The text after the closing ')' is
+// returned as the rest.
+func splitArguments(s string) ([]string, string, error) {
+	var args []string
+	var brackets []rune
+	inString, escaped := false, false
+	start := 0
 	for i, r := range s {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == '"':
+				inString = false
+			}
+			continue
+		}
 		switch r {
-		case ')':
-			s = s[i+1:]
-			if len(currentArgument) > 0 {
-				args = append(args, currentArgument)
+		case '"':
+			inString = true
+		case '[', '{':
+			brackets = append(brackets, r)
+		case ']', '}':
+			n := len(brackets)
+			if n == 0 || (r == ']') != (brackets[n-1] == '[') {
+				return nil, "", fmt.Errorf("syntax error: unexpected '%c'.", r)
 			}
-			goto finish
+			brackets = brackets[:n-1]
 		case ',':
-			args = append(args, currentArgument)
-			currentArgument = ""
-			continue
-		default:
-			currentArgument += string(r)
+			if len(brackets) == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		case ')':
+			if len(brackets) == 0 {
+				args = append(args, s[start:i])
+				return args, s[i+1:], nil
+			}
 		}
 	}
-	err = fmt.Errorf("syntax error: ')' not found.")
-	goto ret
-finish:
-	if len(s) != 0 {
-		err = fmt.Errorf("syntax error: extra characters found after ')'.")
+	if inString {
+		return nil, "", fmt.Errorf("syntax error: unterminated string.")
+	}
+	return nil, "", fmt.Errorf("syntax error: ')' not found.")
+}
+
+// splitNamed checks if an argument looks like name=value, where name is
+// an identifier.
+func splitNamed(a string) (string, string, bool) {
+	eq := strings.IndexRune(a, '=')
+	if eq <= 0 {
+		return "", "", false
+	}
+	key := strings.TrimSpace(a[:eq])
+	for i, r := range key {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return "", "", false
+		}
 	}
-ret:
-	return name, args, err
+	return key, strings.TrimSpace(a[eq+1:]), true
 }
@@ -0,0 +1,64 @@
+package commander
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFunctionString(t *testing.T) {
+	cases := []struct {
+		in    string
+		name  string
+		args  string
+		named map[string]interface{}
+	}{
+		{`hello()`, "hello", `null`, nil},
+		{` hello ( ) `, "hello", `null`, nil},
+		{`chat("hello world")`, "chat", `["hello world"]`, nil},
+		{`chat("a, b", "c)")`, "chat", `["a, b", "c)"]`, nil},
+		{`chat("say \"hi\", ok")`, "chat", `["say \"hi\", ok"]`, nil},
+		{`add(1, 2,)`, "add", `[1, 2]`, nil},
+		{`spawn([1, [2, 3]], {"a": [4, 5], "b": "}"})`, "spawn",
+			`[[1, [2, 3]], {"a": [4, 5], "b": "}"}]`, nil},
+		{`move(x=3, y = 4)`, "move", `null`, map[string]interface{}{"x": 3.0, "y": 4.0}},
+		{`move("bob", to={"x": 1, "y": 2})`, "move", `["bob"]`,
+			map[string]interface{}{"to": map[string]interface{}{"x": 1.0, "y": 2.0}}},
+		{`chat("a=b")`, "chat", `["a=b"]`, nil},
+	}
+	for _, c := range cases {
+		cmd, err := ParseFunctionString(c.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.in, err)
+			continue
+		}
+		args, _ := decode(c.args).([]interface{})
+		if cmd.Name != c.name || !reflect.DeepEqual(cmd.Args, args) || !reflect.DeepEqual(cmd.Named, c.named) {
+			t.Errorf("%s: got %s", c.in, cmd)
+		}
+	}
+}
+
+func TestParseFunctionStringErrors(t *testing.T) {
+	cases := []struct {
+		in  string
+		err string
+	}{
+		{`hello`, "Parser: syntax error: '(' not found."},
+		{`hello(1`, "Parser: syntax error: ')' not found."},
+		{`hello(1))`, "Parser: syntax error: extra characters found after ')'."},
+		{`chat("hi)`, "Parser: syntax error: unterminated string."},
+		{`add([1, 2}, 3)`, "Parser: syntax error: unexpected '}'."},
+		{`add(1,, 2)`, "Parser: syntax error: empty argument 2."},
+		{`add(,)`, "Parser: syntax error: empty argument 1."},
+		{`move(x=1, 2)`, "Parser: syntax error: positional argument 2 after named arguments."},
+		{`move(x=1, x=2)`, "Parser: syntax error: argument x given twice."},
+		{`chat(hello)`, "Parser: JSON syntax error in argument hello."},
+		{`move(x=north)`, "Parser: JSON syntax error in argument x."},
+	}
+	for _, c := range cases {
+		_, err := ParseFunctionString(c.in)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s:\n got: %v\nwant: %s", c.in, err, c.err)
+		}
+	}
+}
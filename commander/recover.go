@@ -0,0 +1,96 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime/debug"
+	"time"
+)
+
+// PanicError is returned by Call when a command panics.  The panic is
+// recovered, so that a broken command can't take down the goroutine
+// that called it, and the stack trace is logged.
+type PanicError struct {
+	Command string
+	Value   interface{}
+	Stack   []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("The command %v panicked: %v", e.Command, e.Value)
+}
+
+func newPanicError(name string, v interface{}) *PanicError {
+	e := &PanicError{Command: name, Value: v, Stack: debug.Stack()}
+	log.Printf("commander: %v\n%s", e, e.Stack)
+	return e
+}
+
+// TimeoutError is returned by Call when a command doesn't finish
+// within its timeout.  The command keeps running in the background,
+// but its result is thrown away.  Commands that take a context.Context
+// can watch it to find out that they should give up.
+type TimeoutError struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("The command %v did not finish within %v.", e.Command, e.Timeout)
+}
+
+// Unwrap allows errors.Is(err, context.DeadlineExceeded) to match.
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// timeout is how long the function may run for, or 0 for no limit.
+func (c *Center) timeout(f *Function) time.Duration {
+	switch {
+	case f.Timeout > 0:
+		return f.Timeout
+	case f.Timeout < 0:
+		return 0
+	}
+	return c.Timeout
+}
+
+// invoke calls the function, recovering from any panic.  When the
+// context can be cancelled, the function runs in its own goroutine, so
+// that the caller can stop waiting for it.
+func invoke(ctx context.Context, name string, timeout time.Duration, s *signature, args []reflect.Value) (interface{}, error) {
+	if ctx.Done() == nil {
+		return callSafely(name, s, args)
+	}
+
+	type outcome struct {
+		result interface{}
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := callSafely(name, s, args)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded && timeout > 0 {
+			return nil, &TimeoutError{Command: name, Timeout: timeout}
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func callSafely(name string, s *signature, args []reflect.Value) (result interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			result, err = nil, newPanicError(name, v)
+		}
+	}()
+	return unpackResult(s.t, s.fn.Call(args))
+}
@@ -0,0 +1,114 @@
+package commander
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func explode(s string) string {
+	panic("boom: " + s)
+}
+
+func pick(i int) int {
+	return []int{1, 2, 3}[i]
+}
+
+func sleepy(d time.Duration) string {
+	time.Sleep(d)
+	return "awake"
+}
+
+func patient(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRecoverPanics(t *testing.T) {
+	c := &Center{}
+	c.MustRegister("explode", explode)
+	c.MustRegister("pick", pick)
+
+	_, err := c.Call("explode", "now")
+	var p *PanicError
+	if !errors.As(err, &p) || p.Value != "boom: now" || len(p.Stack) == 0 {
+		t.Fatalf("expected a PanicError, got %#v", err)
+	}
+	if err.Error() != "The command explode panicked: boom: now" {
+		t.Errorf("unexpected message: %v", err)
+	}
+
+	// a runtime error is recovered the same way.
+	_, err = c.Call("pick", 5)
+	expected := "The command pick panicked: runtime error: index out of range [5] with length 3"
+	if fmt.Sprint(err) != expected {
+		t.Errorf("\n got: %v\nwant: %s", err, expected)
+	}
+
+	// and so is a panic in the middleware.
+	c.Use(func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (interface{}, error) {
+			var m map[string]int
+			m[req.Name] = 1
+			return next(ctx, req)
+		}
+	})
+	if _, err := c.Call("pick", 1); !errors.As(err, &p) {
+		t.Errorf("expected a PanicError from the middleware, got %v", err)
+	}
+
+	resp, _ := json.Marshal(c.HandleJSONRPC(context.Background(),
+		[]byte(`{"jsonrpc": "2.0", "method": "explode", "params": ["x"], "id": 1}`)))
+	expected = `{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error",` +
+		`"data":"The command explode panicked: assignment to entry in nil map"},"id":1}`
+	if string(resp) != expected {
+		t.Errorf("\n got: %s\nwant: %s", resp, expected)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	c := &Center{Timeout: 20 * time.Millisecond}
+	c.MustRegister("sleepy", sleepy)
+	c.MustRegister("patient", patient)
+	c.MustRegister("lazy", &Function{Func: sleepy, Timeout: -1})
+	c.MustRegister("hasty", &Function{Func: sleepy, Timeout: time.Millisecond})
+
+	cases := []struct {
+		name  string
+		sleep string
+		out   string
+	}{
+		{"sleepy", "1ms", "awake <nil>"},
+		{"sleepy", "1s", "<nil> The command sleepy did not finish within 20ms."},
+		{"patient", "", "<nil> The command patient did not finish within 20ms."},
+		{"lazy", "50ms", "awake <nil>"},
+		{"hasty", "10ms", "<nil> The command hasty did not finish within 1ms."},
+	}
+	for _, tc := range cases {
+		var args []interface{}
+		if tc.sleep != "" {
+			args = append(args, tc.sleep)
+		}
+		start := time.Now()
+		result, err := c.Call(tc.name, args...)
+		if out := fmt.Sprint(result, " ", err); out != tc.out {
+			t.Errorf("%s(%s):\n got: %s\nwant: %s", tc.name, tc.sleep, out, tc.out)
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: %v should be a DeadlineExceeded", tc.name, err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("%s(%s): took %v", tc.name, tc.sleep, d)
+		}
+	}
+
+	// cancelling the caller's context stops the wait, too.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&Center{FuncMap: c.FuncMap}).CallContext(ctx, "patient"); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
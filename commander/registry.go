@@ -0,0 +1,148 @@
+package commander
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Register adds a command to the Center, creating the FuncMap if
+// needed.  f is either a function or a *Function, which can carry the
+// parameter names and the description of the command.  An error is
+// returned when f can't be called as a command, or when there is
+// already a command by the same name, so mistakes show up when the
+// server starts rather than when the command is first used.
+func (c *Center) Register(name string, f interface{}) error {
+	if _, err := asFunction(f).signature(name); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.FuncMap[name]; ok {
+		return fmt.Errorf("The command %v already exists.", name)
+	}
+	c.set(name, f)
+	return nil
+}
+
+// MustRegister is like Register, but panics when f can't be added.
+func (c *Center) MustRegister(name string, f interface{}) {
+	if err := c.Register(name, f); err != nil {
+		panic(err)
+	}
+}
+
+// Replace swaps the function of an existing command.  Calls that have
+// already started finish with the old function.  Whether the command
+// is disabled stays the same.
+func (c *Center) Replace(name string, f interface{}) error {
+	if _, err := asFunction(f).signature(name); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.FuncMap[name]; !ok {
+		return &NotFoundError{name}
+	}
+	c.set(name, f)
+	return nil
+}
+
+// Unregister removes a command.  Calls that have already started are
+// allowed to finish.
+func (c *Center) Unregister(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.FuncMap[name]; !ok {
+		return &NotFoundError{name}
+	}
+	delete(c.FuncMap, name)
+	delete(c.disabled, name)
+	return nil
+}
+
+// registerAll adds several commands at once, and adds none of them if
+// any of the names are taken.  The functions have already been checked.
+func (c *Center) registerAll(functions map[string]*Function) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name := range functions {
+		if _, ok := c.FuncMap[name]; ok {
+			return fmt.Errorf("The command %v already exists.", name)
+		}
+	}
+	for name, f := range functions {
+		c.set(name, f)
+	}
+	return nil
+}
+
+// set adds a command.  The caller holds the lock.
+func (c *Center) set(name string, f interface{}) {
+	if c.FuncMap == nil {
+		c.FuncMap = map[string]interface{}{}
+	}
+	c.FuncMap[name] = f
+}
+
+// Disable turns off a command without removing it.  Calling it returns
+// a *DisabledError until it is enabled again.
+func (c *Center) Disable(name string) error {
+	return c.setEnabled(name, false)
+}
+
+// Enable turns a disabled command back on.
+func (c *Center) Enable(name string) error {
+	return c.setEnabled(name, true)
+}
+
+func (c *Center) setEnabled(name string, enabled bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.FuncMap[name]; !ok {
+		return &NotFoundError{name}
+	}
+	if enabled {
+		delete(c.disabled, name)
+		return nil
+	}
+	if c.disabled == nil {
+		c.disabled = map[string]bool{}
+	}
+	c.disabled[name] = true
+	return nil
+}
+
+// Enabled reports whether a command can be called.  Commands that
+// don't exist aren't enabled, with the exception of rpc.discover.
+func (c *Center) Enabled(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.FuncMap[name]
+	if !ok && name == DiscoverMethod {
+		ok = true
+	}
+	return ok && !c.disabled[name]
+}
+
+// Function returns the details of a command.
+func (c *Center) Function(name string) (*Function, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.FuncMap[name]
+	if !ok {
+		return nil, false
+	}
+	return asFunction(entry), true
+}
+
+// Names returns the names of the commands in the Center, sorted.
+func (c *Center) Names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.FuncMap))
+	for name := range c.FuncMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,101 @@
+package commander
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	c := &Center{}
+	c.MustRegister("add", add)
+
+	var nf *NotFoundError
+	var disabled *DisabledError
+	steps := []struct {
+		do  func() error
+		err string
+	}{
+		{func() error { return c.Register("add", multInt) }, "The command add already exists."},
+		{func() error { return c.Replace("mul", multInt) }, "Command mul Not Found."},
+		{func() error { return c.Replace("add", "nope") }, "The command add is not a function."},
+		{func() error { return c.Disable("mul") }, "Command mul Not Found."},
+		{func() error { return c.Unregister("mul") }, "Command mul Not Found."},
+	}
+	for _, s := range steps {
+		if err := s.do(); fmt.Sprint(err) != s.err {
+			t.Errorf("\n got: %v\nwant: %s", err, s.err)
+		}
+	}
+
+	check := func(expected string) {
+		t.Helper()
+		result, err := c.Call("add", 3, 4)
+		if out := fmt.Sprint(result, " ", err); out != expected {
+			t.Errorf("\n got: %s\nwant: %s", out, expected)
+		}
+	}
+	check("7 <nil>")
+
+	if err := c.Replace("add", multInt); err != nil {
+		t.Fatal(err)
+	}
+	check("12 <nil>")
+
+	if err := c.Disable("add"); err != nil {
+		t.Fatal(err)
+	}
+	check("<nil> The command add is disabled.")
+	if _, err := c.Call("add", 3, 4); !errors.As(err, &disabled) || c.Enabled("add") {
+		t.Errorf("expected a DisabledError, got %v", err)
+	}
+
+	// replacing a command keeps it disabled.
+	c.Replace("add", add)
+	check("<nil> The command add is disabled.")
+	c.Enable("add")
+	check("7 <nil>")
+
+	// unregistering forgets that it was disabled.
+	c.Disable("add")
+	if err := c.Unregister("add"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Call("add", 3, 4); !errors.As(err, &nf) {
+		t.Errorf("expected a NotFoundError, got %v", err)
+	}
+	c.MustRegister("add", add)
+	check("7 <nil>")
+}
+
+// TestRegistryRace is meant to be run with -race.
+func TestRegistryRace(t *testing.T) {
+	c := &Center{}
+	c.MustRegister("add", add)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprint("cmd", i)
+			for j := 0; j < 100; j++ {
+				c.Register(name, add)
+				c.Disable("add")
+				c.Enable("add")
+				c.Replace(name, multInt)
+				c.Unregister(name)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Call("add", 1, 2)
+				c.HelpMessage()
+				c.Discover()
+			}
+		}()
+	}
+	wg.Wait()
+}
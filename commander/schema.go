@@ -0,0 +1,278 @@
+package commander
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DiscoverMethod is the name of the built-in command that returns the
+// OpenRPC document of a Center.  It is answered by every Center, unless
+// the FuncMap has a command by the same name.
+const DiscoverMethod = "rpc.discover"
+
+// openRPCVersion is the version of the OpenRPC specification that the
+// documents follow.
+const openRPCVersion = "1.2.6"
+
+// DiscoverInfo is the title and version of the API, as shown in the
+// OpenRPC documents.
+var DiscoverInfo = OpenRPCInfo{Title: "commander", Version: "1.0.0"}
+
+// OpenRPC is a description of all of the commands in a Center,
+// following the OpenRPC specification (https://spec.open-rpc.org).
+// Tools can use it to generate typed clients for the commands.
+type OpenRPC struct {
+	OpenRPC    string            `json:"openrpc"`
+	Info       OpenRPCInfo       `json:"info"`
+	Methods    []OpenRPCMethod   `json:"methods"`
+	Components OpenRPCComponents `json:"components"`
+}
+
+type OpenRPCInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenRPCMethod describes a single command.
+type OpenRPCMethod struct {
+	Name           string              `json:"name"`
+	Description    string              `json:"description,omitempty"`
+	Tags           []OpenRPCTag        `json:"tags,omitempty"`
+	ParamStructure string              `json:"paramStructure"`
+	Params         []ContentDescriptor `json:"params"`
+	Result         ContentDescriptor   `json:"result"`
+}
+
+type OpenRPCTag struct {
+	Name string `json:"name"`
+}
+
+// ContentDescriptor describes a parameter or the result of a command.
+type ContentDescriptor struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Schema      Schema `json:"schema"`
+}
+
+// OpenRPCComponents holds the schemas of the named struct types, which
+// are referred to from the methods with "$ref".
+type OpenRPCComponents struct {
+	Schemas map[string]Schema `json:"schemas"`
+}
+
+// Schema is a JSON Schema.
+type Schema map[string]interface{}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Discover describes the commands of the Center as an OpenRPC
+// document.  The methods are sorted by name.  Functions that can't be
+// called as commands are left out.
+func (c *Center) Discover() *OpenRPC {
+	doc := &OpenRPC{
+		OpenRPC:    openRPCVersion,
+		Info:       DiscoverInfo,
+		Methods:    []OpenRPCMethod{},
+		Components: OpenRPCComponents{Schemas: map[string]Schema{}},
+	}
+	for _, name := range c.Names() {
+		f, ok := c.Function(name)
+		if !ok {
+			continue
+		}
+		s, err := f.signature(name)
+		if err != nil {
+			continue
+		}
+		doc.Methods = append(doc.Methods, f.describe(name, s, doc.Components.Schemas))
+	}
+	return doc
+}
+
+// ServeDiscover writes the OpenRPC document of the Center as JSON.
+func (c *Center) ServeDiscover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c.Discover()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// describe builds the OpenRPC method of a function.  The schemas of
+// any named struct types are added to defs.
+func (f *Function) describe(name string, s *signature, defs map[string]Schema) OpenRPCMethod {
+	m := OpenRPCMethod{
+		Name:           name,
+		Description:    f.Description,
+		ParamStructure: "by-position",
+		Params:         []ContentDescriptor{},
+	}
+	if len(f.Params) > 0 {
+		m.ParamStructure = "either"
+	}
+	for _, tag := range f.Tags {
+		m.Tags = append(m.Tags, OpenRPCTag{tag})
+	}
+
+	required := len(s.params) - len(f.Defaults)
+	for i, t := range s.params {
+		p := ContentDescriptor{
+			Name:        f.paramName(i),
+			Description: f.paramDoc(i),
+			Required:    i < required,
+			Schema:      typeSchema(t, defs),
+		}
+		if i >= required {
+			p.Schema = withDefault(p.Schema, f.Defaults[i-required])
+		}
+		m.Params = append(m.Params, p)
+	}
+	if s.variadic != nil {
+		i := len(s.params)
+		m.Params = append(m.Params, ContentDescriptor{
+			Name:        f.paramName(i),
+			Description: f.paramDoc(i),
+			Schema:      typeSchema(reflect.SliceOf(s.variadic), defs),
+		})
+	}
+
+	m.Result = ContentDescriptor{Name: "result", Schema: resultSchema(s.t, defs)}
+	return m
+}
+
+// paramName is the name of the i-th parameter, or "argN" when the
+// function doesn't have names for its parameters.
+func (f *Function) paramName(i int) string {
+	if i < len(f.Params) {
+		return f.Params[i]
+	}
+	return "arg" + strconv.Itoa(i+1)
+}
+
+func (f *Function) paramDoc(i int) string {
+	if i < len(f.ParamDocs) {
+		return f.ParamDocs[i]
+	}
+	return ""
+}
+
+func withDefault(s Schema, v interface{}) Schema {
+	out := Schema{}
+	for k, val := range s {
+		out[k] = val
+	}
+	out["default"] = v
+	return out
+}
+
+// resultSchema describes the value returned by Call for a function
+// type.  A trailing error is left out, and several outputs become an
+// array with one item per output.
+func resultSchema(t reflect.Type, defs map[string]Schema) Schema {
+	var outs []reflect.Type
+	for i := 0; i < t.NumOut(); i++ {
+		outs = append(outs, t.Out(i))
+	}
+	if len(outs) > 0 && outs[len(outs)-1] == errorType {
+		outs = outs[:len(outs)-1]
+	}
+	switch len(outs) {
+	case 0:
+		return Schema{"type": "null"}
+	case 1:
+		return typeSchema(outs[0], defs)
+	}
+	var items []Schema
+	for _, t := range outs {
+		items = append(items, typeSchema(t, defs))
+	}
+	return Schema{
+		"type":     "array",
+		"items":    items,
+		"minItems": len(items),
+		"maxItems": len(items),
+	}
+}
+
+// typeSchema builds the JSON Schema of the values that convertArg
+// accepts for a type, which is also how encoding/json writes it.
+// Named struct types are put in defs and referred to, so that
+// recursive types work and generated clients can reuse them.
+func typeSchema(t reflect.Type, defs map[string]Schema) Schema {
+	if t == durationType {
+		return Schema{"type": "string", "description": "a duration, like \"1m30s\""}
+	}
+	// types that encode themselves could look like anything, except for
+	// the ones that encode as text.
+	switch {
+	case t.Implements(jsonMarshalerType):
+		return Schema{}
+	case t.Implements(textMarshalerType):
+		return Schema{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return Schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Ptr:
+		return Schema{"oneOf": []Schema{typeSchema(t.Elem(), defs), {"type": "null"}}}
+	case reflect.Slice:
+		return Schema{"type": "array", "items": typeSchema(t.Elem(), defs)}
+	case reflect.Array:
+		return Schema{
+			"type":     "array",
+			"items":    typeSchema(t.Elem(), defs),
+			"minItems": t.Len(),
+			"maxItems": t.Len(),
+		}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		name := t.String()
+		if _, ok := defs[name]; !ok {
+			defs[name] = nil // placeholder, in case the type refers to itself.
+			defs[name] = structSchema(t, defs)
+		}
+		return Schema{"$ref": "#/components/schemas/" + name}
+	}
+	return Schema{}
+}
+
+// structSchema lists the exported fields of a struct, by the names
+// that encoding/json gives them.
+func structSchema(t reflect.Type, defs map[string]Schema) Schema {
+	props := Schema{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		props[name] = typeSchema(f.Type, defs)
+	}
+	return Schema{"type": "object", "properties": props}
+}
@@ -0,0 +1,117 @@
+package commander
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type node struct {
+	Name     string  `json:"name"`
+	Children []*node `json:"children"`
+	secret   int
+}
+
+func grow(root node, depth uint, labels ...string) (*node, error) {
+	return &root, nil
+}
+
+func discoverCenter() *Center {
+	c := &Center{}
+	c.MustRegister("walk", &Function{
+		Func:        walk,
+		Defaults:    []interface{}{10, "north"},
+		Params:      []string{"name", "steps", "direction"},
+		ParamDocs:   []string{"who is walking"},
+		Description: "Walks a few steps.",
+		Tags:        []string{"player"},
+	})
+	c.MustRegister("grow", grow)
+	c.MustRegister("divmod", divmod)
+	return c
+}
+
+func TestDiscover(t *testing.T) {
+	expected := `{
+	  "openrpc": "1.2.6",
+	  "info": {"title": "commander", "version": "1.0.0"},
+	  "methods": [
+	    {"name": "divmod", "paramStructure": "by-position",
+	     "params": [
+	       {"name": "arg1", "required": true, "schema": {"type": "integer"}},
+	       {"name": "arg2", "required": true, "schema": {"type": "integer"}}],
+	     "result": {"name": "result", "schema": {"type": "array", "minItems": 2, "maxItems": 2,
+	       "items": [{"type": "integer"}, {"type": "integer"}]}}},
+	    {"name": "grow", "paramStructure": "by-position",
+	     "params": [
+	       {"name": "arg1", "required": true, "schema": {"$ref": "#/components/schemas/commander.node"}},
+	       {"name": "arg2", "required": true, "schema": {"type": "integer", "minimum": 0}},
+	       {"name": "arg3", "schema": {"type": "array", "items": {"type": "string"}}}],
+	     "result": {"name": "result", "schema": {"oneOf": [
+	       {"$ref": "#/components/schemas/commander.node"}, {"type": "null"}]}}},
+	    {"name": "walk", "description": "Walks a few steps.", "tags": [{"name": "player"}],
+	     "paramStructure": "either",
+	     "params": [
+	       {"name": "name", "description": "who is walking", "required": true, "schema": {"type": "string"}},
+	       {"name": "steps", "schema": {"type": "integer", "default": 10}},
+	       {"name": "direction", "schema": {"type": "string", "default": "north"}}],
+	     "result": {"name": "result", "schema": {"type": "string"}}}
+	  ],
+	  "components": {"schemas": {
+	    "commander.node": {"type": "object", "properties": {
+	      "name": {"type": "string"},
+	      "children": {"type": "array", "items": {"oneOf": [
+	        {"$ref": "#/components/schemas/commander.node"}, {"type": "null"}]}}}}
+	  }}
+	}`
+	var want interface{}
+	json.Unmarshal([]byte(expected), &want)
+
+	// the document is checked both directly, and as the result of the
+	// rpc.discover command.
+	b, _ := json.Marshal(discoverCenter().Discover())
+	var got interface{}
+	json.Unmarshal(b, &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Discover:\n got: %s", b)
+	}
+
+	resp, _ := json.Marshal(discoverCenter().HandleJSONRPC(context.Background(),
+		[]byte(`{"jsonrpc": "2.0", "method": "rpc.discover", "id": 1}`)))
+	var rpc struct{ Result interface{} }
+	json.Unmarshal(resp, &rpc)
+	if !reflect.DeepEqual(rpc.Result, want) {
+		t.Errorf("rpc.discover:\n got: %s", resp)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	c := &Center{}
+	if err := c.Register("notAFunc", 5); err == nil {
+		t.Error("registering a non-function should fail")
+	}
+	if err := c.Register("walk", WithDefaults(walk, 1, 2, 3, 4)); err == nil {
+		t.Error("registering too many defaults should fail")
+	}
+	if err := c.Register("add", add); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if result, err := c.Call("add", 1, 2); err != nil || result != 3 {
+		t.Errorf("add(1, 2) returned %v, %v", result, err)
+	}
+}
+
+func TestHelpMessage(t *testing.T) {
+	expected := strings.Join([]string{
+		"",
+		"divmod(int, int) (int, int)",
+		"grow(commander.node, uint, ...string) (*commander.node, error)",
+		"walk(name string, steps int, direction string) string defaults:[10 north]",
+		"\tWalks a few steps.",
+	}, "\n\t")
+	if got := discoverCenter().HelpMessage(); got != expected {
+		t.Errorf("\n got: %q\nwant: %q", got, expected)
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tilegame/gameserver/commander"
+	"github.com/tilegame/gameserver/cookiez"
+	"github.com/tilegame/gameserver/gamestate"
+	"github.com/tilegame/gameserver/tilemap"
+	"github.com/tilegame/gameserver/wshandle"
+)
+
+// clientroom holds the players connected to the main websocket
+// endpoint, /ws.
+var clientroom = wshandle.NewClientRoom()
+
+// commandCenter contains the commands that can be sent to /ws, either
+// as JSON like {"Name": "move", "Args": ["alice", 3, 4]}, or in the
+// function syntax like move("alice", 3, 4).  The commands are
+// registered during init, since help refers to the commandCenter
+// itself.
+//
+// Commands are given a few seconds, so that a stuck command can't hold
+// up every other message sent to /ws.
+var commandCenter = &commander.Center{Timeout: 5 * time.Second}
+
+// The tags say who can use a command, and are checked by checkTags:
+// "guest" commands can be sent by anyone connected to /ws, while
+// "player" commands change the game, and need the client to have added
+// a player first.  The name given to a "player" command has to be the
+// client's own player.
+//
+// The commands that change the players have no timeout.  A command that
+// timed out would keep running, and could still change the game after
+// its client was told that it failed.  They only wait on the Game Hub,
+// which answers them promptly.
+func init() {
+	commander.DiscoverInfo.Title = "tilegame"
+	commandCenter.Use(logCommands)
+	commandCenter.Use(touchSessions)
+	commandCenter.Use(checkTags)
+
+	commandCenter.MustRegister("hello", &commander.Function{
+		Func:        cmdHello,
+		Description: "Says hello back.",
+		Tags:        []string{"guest"},
+	})
+	commandCenter.MustRegister("help", &commander.Function{
+		Func:        cmdHelp,
+		Description: "Lists the commands.",
+		Tags:        []string{"guest"},
+	})
+	commandCenter.MustRegister("whoami", &commander.Function{
+		Func:        cmdWhoami,
+		Description: "Returns the id of your websocket connection, and the user it belongs to.",
+		Tags:        []string{"guest"},
+	})
+	commandCenter.MustRegister("list", &commander.Function{
+		Func:        cmdList,
+		Description: "Returns the players, by name.",
+		Tags:        []string{"guest"},
+	})
+	commandCenter.MustRegister("map", &commander.Function{
+		Func:        cmdMap,
+		Description: "Returns the current map.",
+		Tags:        []string{"guest"},
+	})
+	commandCenter.MustRegister("add", &commander.Function{
+		Func:        cmdAdd,
+		Params:      []string{"name"},
+		ParamDocs:   []string{"the name of the new player"},
+		Description: "Adds a player at the spawn point, which the client then controls.",
+		Tags:        []string{"guest"},
+		Timeout:     -1,
+	})
+	commandCenter.MustRegister("remove", &commander.Function{
+		Func:        cmdRemove,
+		Params:      []string{"name"},
+		Description: "Removes a player from the game.",
+		Tags:        []string{"player"},
+		Timeout:     -1,
+	})
+	commandCenter.MustRegister("move", &commander.Function{
+		Func:        cmdMove,
+		Params:      []string{"name", "x", "y"},
+		ParamDocs:   []string{"the player to move", "the target column", "the target row"},
+		Description: "Walks a player to a tile, going around anything in the way.",
+		Tags:        []string{"player"},
+		Timeout:     -1,
+	})
+	commandCenter.MustRegister("ack", &commander.Function{
+		Func:        cmdAck,
+		Params:      []string{"tick"},
+		Description: "Acknowledges a state message, so that the next ones only hold what changed since it.",
+		Tags:        []string{"guest"},
+	})
+	commandCenter.MustRegister("chat", &commander.Function{
+		Func:        cmdChat,
+		Params:      []string{"name", "message"},
+		Description: "Sends a message from a player to everyone.",
+		Tags:        []string{"player"},
+	})
+	commandCenter.MustRegister("say", &commander.Function{
+		Func:        cmdSay,
+		Params:      []string{"name", "message"},
+		Description: "Sends a message from a player to the players close enough to hear it.",
+		Tags:        []string{"player"},
+	})
+}
+
+// registerGameMethods makes the game itself available in the "game"
+// namespace, like game.movePlayer("alice", 3, 4).  Players are only
+// added with the add command, which gives them to the client that
+// added them.  It is called once the game has been made.
+func registerGameMethods() {
+	err := commandCenter.RegisterMethods("game", game, &commander.MethodOptions{
+		Include: []string{"RemovePlayer", "MovePlayer", "Player", "PlayersAt", "Uptime"},
+		Functions: map[string]*commander.Function{
+			"RemovePlayer": {
+				Params:      []string{"name"},
+				Description: "Removes a player from the game.",
+				Tags:        []string{"player"},
+				Timeout:     -1,
+			},
+			"MovePlayer": {
+				Params:      []string{"name", "x", "y"},
+				Description: "Walks a player to a tile.",
+				Tags:        []string{"player"},
+				Timeout:     -1,
+			},
+			"Player": {
+				Params:      []string{"name"},
+				Description: "Returns a player, and whether it exists.",
+				Tags:        []string{"guest"},
+			},
+			"PlayersAt": {
+				Params:      []string{"x", "y"},
+				Description: "Returns the names of the players standing on a tile.",
+				Tags:        []string{"guest"},
+			},
+			"Uptime": {
+				Description: "Returns how long the game has been running, in nanoseconds.",
+				Tags:        []string{"guest"},
+			},
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// slowCommand is how long a command can take before it is logged.
+const slowCommand = 100 * time.Millisecond
+
+// logCommands is middleware that logs the commands that fail or take
+// a long time.
+func logCommands(next commander.Handler) commander.Handler {
+	return func(ctx context.Context, req *commander.Request) (interface{}, error) {
+		start := time.Now()
+		result, err := next(ctx, req)
+		if d := time.Since(start); err != nil || d > slowCommand {
+			log.Printf("command %s took %v: error: %v", req.Name, d, err)
+		}
+		return result, err
+	}
+}
+
+// touchSessions is middleware that keeps the sessions of the clients
+// going while they send commands.  Once a session has ended, its client
+// has to reconnect with a new cookie.
+func touchSessions(next commander.Handler) commander.Handler {
+	return func(ctx context.Context, req *commander.Request) (interface{}, error) {
+		if c, ok := wshandle.ClientFromContext(ctx); ok {
+			if s, ok := c.Session.(cookiez.Session); ok {
+				if err := cookieServer.Touch(s); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+var (
+	// errNoPlayer is returned for "player" commands sent by a client
+	// that hasn't added a player.
+	errNoPlayer = errors.New("this command needs a player; add one first.")
+
+	// errNotYourPlayer is returned for "player" commands that name
+	// someone else's player.
+	errNotYourPlayer = errors.New("that is not your player.")
+
+	// errHasPlayer is returned when a client that already has a
+	// player tries to add another.
+	errHasPlayer = errors.New("you already have a player; remove it first.")
+)
+
+// checkTags is middleware that makes sure that only the clients with a
+// player of their own can use the "player" commands, and only on that
+// player.  Calls that don't come from a websocket client, like from the
+// server itself, are let through.
+func checkTags(next commander.Handler) commander.Handler {
+	return func(ctx context.Context, req *commander.Request) (interface{}, error) {
+		c, ok := wshandle.ClientFromContext(ctx)
+		if !ok || req.Function == nil {
+			return next(ctx, req)
+		}
+		for _, tag := range req.Function.Tags {
+			if tag != "player" {
+				continue
+			}
+			own, ok := playerOf(c.Id)
+			if !ok {
+				return nil, errNoPlayer
+			}
+			if name, ok := req.Arg("name"); ok && name != own {
+				return nil, errNotYourPlayer
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// broadcastMessage is the structure of the messages sent to every
+// client in the clientroom.  Kind tells the client what to expect in
+// the Result.
+type broadcastMessage struct {
+	Kind   string      `json:"kind"`
+	Result interface{} `json:"result"`
+}
+
+// history remembers the recent snapshots of the game, and which of
+// them each client has acknowledged with the ack command.
+var history = gamestate.NewHistory()
+
+// hearingRadius is how far away, in tiles, the players can hear the
+// say command.
+var hearingRadius = 8.0
+
+// viewRadius is how far away, in tiles, a client can see other players
+// from its own.  Clients that haven't added a player, or a radius of
+// zero, see everyone.
+var viewRadius float64
+
+// clientPlayers remembers which player each client added, so that the
+// state it is sent can be centered on that player, and so that only
+// that client can control it.  The game tells unbindPlayer about every
+// player that leaves, however it was removed, so a name that is added
+// again doesn't belong to its old client.
+var clientPlayers = struct {
+	sync.Mutex
+	byClient map[int]string
+}{byClient: map[int]string{}}
+
+func bindPlayer(client int, name string) {
+	clientPlayers.Lock()
+	defer clientPlayers.Unlock()
+	clientPlayers.byClient[client] = name
+}
+
+// unbindPlayer forgets the clients of a player that has left the game.
+func unbindPlayer(name string) {
+	clientPlayers.Lock()
+	defer clientPlayers.Unlock()
+	for id, n := range clientPlayers.byClient {
+		if n == name {
+			delete(clientPlayers.byClient, id)
+		}
+	}
+}
+
+// ownerOf returns the client that added a player.
+func ownerOf(name string) (int, bool) {
+	clientPlayers.Lock()
+	defer clientPlayers.Unlock()
+	for id, n := range clientPlayers.byClient {
+		if n == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func playerOf(client int) (string, bool) {
+	clientPlayers.Lock()
+	defer clientPlayers.Unlock()
+	name, ok := clientPlayers.byClient[client]
+	return name, ok
+}
+
+// clientsOf returns the ids of the clients that added the players.
+func clientsOf(names []string) []int {
+	clientPlayers.Lock()
+	defer clientPlayers.Unlock()
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var ids []int
+	for id, name := range clientPlayers.byClient {
+		if wanted[name] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func forgetClient(client int) {
+	clientPlayers.Lock()
+	defer clientPlayers.Unlock()
+	delete(clientPlayers.byClient, client)
+}
+
+// startGameEndpoint connects the clientroom to the command center, and
+// sends the state of the players to each client after every tick of
+// the game.  Only the clients with a session cookie from /cookie are
+// let in.
+func startGameEndpoint() {
+	registerGameMethods()
+	err := game.OnRemove(func(p gamestate.Player) { unbindPlayer(p.Name) })
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientroom.Authenticate = authenticate
+	go clientroom.ServeCommands(commandCenter)
+	go runClientroomBroadcaster(game.Subscribe())
+}
+
+// runClientroomBroadcaster sends each client a "state" message after
+// every tick.  Clients that acknowledge the ticks they receive are only
+// sent what changed since then, and everyone else gets all of the
+// players every time.  Clients with a player of their own only hear
+// about the players within the viewRadius of it.
+//
+// The clients that have always seen everyone, and acknowledged the same
+// tick, are sent the same Update, so it is only encoded once for each
+// codec.  A client that has been filtered before has views of its own
+// to compare against, so it is always sent an Update of its own.
+func runClientroomBroadcaster(snapshots <-chan gamestate.Snapshot) {
+	type shared struct {
+		full bool
+		base uint64
+	}
+	connected := map[int]bool{}
+	filtered := map[int]bool{}
+	for s := range snapshots {
+		history.Add(s)
+		var grid *gamestate.Grid
+		if viewRadius > 0 {
+			grid = gamestate.GridOf(s.Players, viewRadius)
+		}
+		clients := clientroom.Clients()
+		stillConnected := make(map[int]bool, len(clients))
+		updates := map[shared]gamestate.Update{}
+		receivers := map[shared][]*wshandle.Client{}
+		for _, c := range clients {
+			stillConnected[c.Id] = true
+			keep := nearbyFilter(grid, s, c.Id)
+			u, ok := history.Update(c.Id, keep)
+			if !ok {
+				continue
+			}
+			if keep != nil {
+				filtered[c.Id] = true
+			}
+			if filtered[c.Id] {
+				c.Send(broadcastMessage{"state", u})
+				continue
+			}
+			key := shared{u.Full, u.Base}
+			if _, ok := updates[key]; !ok {
+				updates[key] = u
+			}
+			receivers[key] = append(receivers[key], c)
+		}
+		for key, u := range updates {
+			wshandle.SendTo(receivers[key], broadcastMessage{"state", u})
+		}
+		for id := range connected {
+			if !stillConnected[id] {
+				history.Forget(id)
+				forgetClient(id)
+				delete(filtered, id)
+			}
+		}
+		connected = stillConnected
+	}
+}
+
+// nearbyFilter keeps the players within the viewRadius of the client's
+// own player, which is always kept.  It returns nil when the client
+// should see everyone.
+func nearbyFilter(grid *gamestate.Grid, s gamestate.Snapshot, client int) gamestate.Filter {
+	if grid == nil {
+		return nil
+	}
+	name, ok := playerOf(client)
+	if !ok {
+		return nil
+	}
+	own, ok := s.Players[name]
+	if !ok {
+		return nil
+	}
+	near := map[string]bool{name: true}
+	for _, n := range grid.Within(own.CurrentPosition, viewRadius) {
+		near[n] = true
+	}
+	return func(name string, p gamestate.Player) bool {
+		return near[name]
+	}
+}
+
+// authenticate finds the user of a websocket connection from their
+// session cookie, and sends them a fresh one with the handshake.
+func authenticate(w http.ResponseWriter, r *http.Request) (wshandle.Identity, error) {
+	s, err := cookieServer.Authenticate(w, r)
+	if err != nil {
+		return wshandle.Identity{}, err
+	}
+	return wshandle.Identity{Username: s.Name, PlayerID: s.PlayerID, Session: s}, nil
+}
+
+func broadcastToClientroom(kind string, result interface{}) {
+	clientroom.Send(broadcastMessage{kind, result})
+}
+
+// ------------------------------------------------------------------
+// Commands
+// ------------------------------------------------------------------
+
+func cmdHello() string {
+	return "well hello to you too!"
+}
+
+// whoami is the result of the whoami command.
+type whoami struct {
+	Id       int
+	Username string
+	PlayerID int
+	Account  bool // false for guests.
+}
+
+// cmdWhoami returns the id of the client that sent the command, along
+// with the user of its session.
+func cmdWhoami(ctx context.Context) (whoami, error) {
+	c, ok := wshandle.ClientFromContext(ctx)
+	if !ok {
+		return whoami{}, errors.New("not called by a websocket client.")
+	}
+	s, _ := c.Session.(cookiez.Session)
+	return whoami{c.Id, c.Username, c.PlayerID, s.Account}, nil
+}
+
+// cmdAck records that the client calling it has received the state of
+// a tick.  It is best sent as a JSON-RPC notification, which isn't
+// answered.
+func cmdAck(ctx context.Context, tick uint64) error {
+	c, ok := wshandle.ClientFromContext(ctx)
+	if !ok {
+		return errors.New("not called by a websocket client.")
+	}
+	return history.Ack(c.Id, tick)
+}
+
+func cmdHelp() string {
+	return commandCenter.HelpMessage()
+}
+
+// cmdAdd adds a player, which becomes the player of the client that
+// sent the command.  Each client only gets one player at a time.
+func cmdAdd(ctx context.Context, name string) error {
+	c, ok := wshandle.ClientFromContext(ctx)
+	if ok {
+		if _, has := playerOf(c.Id); has {
+			return errHasPlayer
+		}
+		if owner, taken := ownerOf(name); taken && owner != c.Id {
+			return gamestate.ErrPlayerExists
+		}
+	}
+	if _, err := game.AddPlayer(name); err != nil {
+		return err
+	}
+	if ok {
+		bindPlayer(c.Id, name)
+	}
+	return nil
+}
+
+func cmdRemove(name string) error {
+	return game.RemovePlayer(name)
+}
+
+func cmdList() map[string]gamestate.Player {
+	return game.Snapshot().Players
+}
+
+func cmdMap() *tilemap.Map {
+	return game.Snapshot().Map
+}
+
+func cmdMove(name string, x, y int) error {
+	return game.MovePlayer(name, x, y)
+}
+
+func cmdChat(name, message string) error {
+	if _, ok := game.Player(name); !ok {
+		return gamestate.ErrNoPlayer
+	}
+	broadcastToClientroom("chat", map[string]string{
+		"User":    name,
+		"Message": message,
+	})
+	return nil
+}
+
+// cmdSay sends a chat message to the clients whose players are near the
+// one speaking.
+func cmdSay(name, message string) error {
+	p, ok := game.Player(name)
+	if !ok {
+		return gamestate.ErrNoPlayer
+	}
+	var listeners []*wshandle.Client
+	for _, id := range clientsOf(game.PlayersNear(p.CurrentPosition, hearingRadius)) {
+		if c, ok := clientroom.Client(id); ok {
+			listeners = append(listeners, c)
+		}
+	}
+	wshandle.SendTo(listeners, broadcastMessage{"chat", map[string]string{
+		"User":    name,
+		"Message": message,
+	}})
+	return nil
+}
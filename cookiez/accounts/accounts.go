@@ -0,0 +1,203 @@
+// Package accounts keeps the registered players, and checks their
+// passwords.  Passwords are never stored, only their bcrypt hashes.
+package accounts
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrNameTaken     = errors.New("that username is already taken.")
+	ErrBadName       = errors.New("usernames are 3 to 24 letters, digits, '-' or '_'.")
+	ErrShortPassword = errors.New("passwords need at least 8 characters.")
+	ErrLongPassword  = errors.New("passwords can't be longer than 72 bytes.")
+	ErrBadLogin      = errors.New("wrong username or password.")
+)
+
+const (
+	minNameLen     = 3
+	maxNameLen     = 24
+	minPasswordLen = 8
+	maxPasswordLen = 72 // the most that bcrypt looks at.
+)
+
+// FirstID is the id of the first account.  It is far away from the ids
+// that the guests are given, which are all below it.
+const FirstID = 1000000
+
+// Cost is the bcrypt cost of the password hashes.  Raising it makes
+// the hashes slower to make and to crack.  Existing hashes keep the
+// cost they were made with.
+var Cost = bcrypt.DefaultCost
+
+// Account is a registered player.
+type Account struct {
+	ID      int
+	Name    string
+	Hash    []byte
+	Created time.Time
+}
+
+// Store holds the accounts, by username.  Usernames are unique no matter
+// how they are capitalized, so "Alice" and "alice" can't both sign up.
+// A Store is safe for concurrent use.
+type Store struct {
+	mu       sync.Mutex
+	path     string
+	accounts map[string]Account // by lowercase username.
+	nextID   int
+}
+
+// NewStore makes an empty Store that is only kept in memory.
+func NewStore() *Store {
+	return &Store{accounts: map[string]Account{}, nextID: FirstID}
+}
+
+// OpenFile loads the accounts saved in a file, or starts with none if
+// the file doesn't exist yet.  New accounts are saved to the same file.
+func OpenFile(path string) (*Store, error) {
+	s := NewStore()
+	s.path = path
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Account
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	for _, a := range list {
+		s.accounts[strings.ToLower(a.Name)] = a
+		if a.ID >= s.nextID {
+			s.nextID = a.ID + 1
+		}
+	}
+	return s, nil
+}
+
+// Len is the number of accounts.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.accounts)
+}
+
+// SignUp makes a new account.
+func (s *Store) SignUp(name, password string) (Account, error) {
+	if err := checkName(name); err != nil {
+		return Account{}, err
+	}
+	if err := checkPassword(password); err != nil {
+		return Account{}, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), Cost)
+	if err != nil {
+		return Account{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(name)
+	if _, ok := s.accounts[key]; ok {
+		return Account{}, ErrNameTaken
+	}
+	a := Account{ID: s.nextID, Name: name, Hash: hash, Created: time.Now()}
+	s.accounts[key] = a
+	if err := s.save(); err != nil {
+		delete(s.accounts, key)
+		return Account{}, err
+	}
+	s.nextID++
+	return a, nil
+}
+
+// dummyHash is compared against when logging in as someone who doesn't
+// exist, so that it takes as long as a wrong password does.  It is
+// made the first time that it is needed.
+var (
+	dummyHash []byte
+	dummyOnce sync.Once
+)
+
+func compareDummy(password string) {
+	dummyOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not anybody's password"), Cost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+// Login checks a password, and returns the account it belongs to.  The
+// username can be capitalized any way.  ErrBadLogin doesn't say if it
+// was the username or the password that was wrong.
+func (s *Store) Login(name, password string) (Account, error) {
+	s.mu.Lock()
+	a, ok := s.accounts[strings.ToLower(name)]
+	s.mu.Unlock()
+	if !ok {
+		compareDummy(password)
+		return Account{}, ErrBadLogin
+	}
+	if bcrypt.CompareHashAndPassword(a.Hash, []byte(password)) != nil {
+		return Account{}, ErrBadLogin
+	}
+	return a, nil
+}
+
+// save writes all of the accounts to the file, if there is one.  The
+// caller holds the lock.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	list := make([]Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	b, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// checkName makes sure that a username is reasonable.  Guests have a
+// space in their names, so they can't be mistaken for an account.
+func checkName(name string) error {
+	if n := len([]rune(name)); n < minNameLen || n > maxNameLen {
+		return ErrBadName
+	}
+	for _, r := range name {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_') {
+			return ErrBadName
+		}
+	}
+	return nil
+}
+
+func checkPassword(password string) error {
+	if len([]rune(password)) < minPasswordLen {
+		return ErrShortPassword
+	}
+	if len(password) > maxPasswordLen {
+		return ErrLongPassword
+	}
+	return nil
+}
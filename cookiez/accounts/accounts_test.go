@@ -0,0 +1,87 @@
+package accounts
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	Cost = bcrypt.MinCost
+}
+
+func TestSignUp(t *testing.T) {
+	s := NewStore()
+	a, err := s.SignUp("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID != FirstID || a.Name != "alice" {
+		t.Errorf("unexpected account %+v", a)
+	}
+	if strings.Contains(string(a.Hash), "correct horse") {
+		t.Error("the password was stored as it is.")
+	}
+
+	cases := []struct {
+		name, password string
+		err            error
+	}{
+		{"alice", "battery staple", ErrNameTaken},
+		{"ALICE", "battery staple", ErrNameTaken},
+		{"al", "battery staple", ErrBadName},
+		{"dizzy starfish", "battery staple", ErrBadName},
+		{strings.Repeat("b", 25), "battery staple", ErrBadName},
+		{"bob", "short", ErrShortPassword},
+		{"bob", strings.Repeat("x", 73), ErrLongPassword},
+	}
+	for _, c := range cases {
+		if _, err := s.SignUp(c.name, c.password); err != c.err {
+			t.Errorf("SignUp(%q, %q): expected %v, got %v", c.name, c.password, c.err, err)
+		}
+	}
+	if b, err := s.SignUp("bob_2", "battery staple"); err != nil || b.ID != FirstID+1 {
+		t.Errorf("SignUp(bob_2) = %+v, %v", b, err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := NewStore()
+	s.SignUp("Alice", "correct horse")
+
+	if a, err := s.Login("alice", "correct horse"); err != nil || a.Name != "Alice" {
+		t.Errorf("Login = %+v, %v", a, err)
+	}
+	if _, err := s.Login("Alice", "Correct Horse"); err != ErrBadLogin {
+		t.Errorf("expected ErrBadLogin for the wrong password, got %v", err)
+	}
+	if _, err := s.Login("nobody", "correct horse"); err != ErrBadLogin {
+		t.Errorf("expected ErrBadLogin for an unknown user, got %v", err)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SignUp("alice", "correct horse")
+	s.SignUp("bob", "battery staple")
+
+	s, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 2 {
+		t.Errorf("expected 2 accounts, got %d", s.Len())
+	}
+	if _, err := s.Login("bob", "battery staple"); err != nil {
+		t.Error(err)
+	}
+	if c, _ := s.SignUp("carol", "battery staple"); c.ID != FirstID+2 {
+		t.Errorf("expected the ids to carry on from %d, got %d", FirstID+2, c.ID)
+	}
+}
@@ -1,22 +1,23 @@
 package cookiez
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/fractalbach/fractalnet/namegen"
 	"github.com/gorilla/securecookie"
+	"github.com/tilegame/gameserver/cookiez/accounts"
 	"github.com/tilegame/gameserver/cookiez/registrar"
 )
 
 const (
-	mainCookieName  = "tilegame-session"
-	hashKeyLen      = 32 // can be 32 or 64 bytes
-	blockKeyLen     = 16 // can be 16, 24, or 32 bytes.
-	sessionDuration = time.Minute * 1
-	maxAgeSeconds   = 60
+	mainCookieName = "tilegame-session"
+	hashKeyLen     = 32 // can be 32 or 64 bytes
+	blockKeyLen    = 16 // can be 16, 24, or 32 bytes.
 )
 
 const loginString = `
@@ -25,7 +26,7 @@ You have logged in!
 Username: %s
 PlayerID: %d
 Token:    %x
-Duration: %s
+TimeLeft: %s
 
 Try Refreshing the page to see if you stay logged in!
 `
@@ -39,23 +40,61 @@ Token:    %x
 TimeLeft: %s
 `
 
+var (
+	ErrNoSession      = errors.New("no session cookie; visit /cookie to get one.")
+	ErrInvalidSession = errors.New("the session cookie is invalid or has expired.")
+)
+
+// Session is who a valid session cookie belongs to.  It can be given
+// to Touch, to keep the session going while it is in use.
+type Session struct {
+	Name     string
+	PlayerID int
+	Account  bool // false for guests.
+	Started  time.Time
+	Expires  time.Time
+	token    []byte
+}
+
 type cookieServer struct {
-	reg                 *registrar.Registrar
-	gorillaSecureCookie *securecookie.SecureCookie
-	uniqueID            int
-	secure              bool
+	reg      registrar.Store
+	accounts *accounts.Store
+	uniqueID int
+	secure   bool
+
+	lifetime Lifetime
+
+	mu      sync.RWMutex // guards the keys, touched, and uniqueID.
+	keys    []KeyPair
+	codecs  []securecookie.Codec
+	keyFile string
+	touched map[touchKey]time.Time // when each session was last refreshed.
+}
+
+// touchKey tells the sessions apart in touched.  A user who logs in
+// again gets a new token, and so a new session.
+type touchKey struct {
+	name  string
+	token string
 }
 
 // Creates a new cookie server that holds a registrar with active user sessions,
 // and can be used to generate new cookies for new users. Defaults to using
 // secure cookies, which will only work when using TLS, but this can be toggled.
+//
+// The cookies are signed with a random key, which only lasts until the
+// server stops.  Use SetKeys or UseKeyFile to keep the same keys.
 func NewCookieServer() *cookieServer {
-	return &cookieServer{
-		reg:                 registrar.NewRegistrar(),
-		gorillaSecureCookie: gimmeCookie(),
-		secure:              true,
-		uniqueID:            123,
+	c := &cookieServer{
+		reg:      registrar.NewRegistrar(),
+		accounts: accounts.NewStore(),
+		secure:   true,
+		uniqueID: 123,
+		lifetime: DefaultLifetime,
+		touched:  map[touchKey]time.Time{},
 	}
+	c.SetKeys()
+	return c
 }
 
 // Set to true in order to serve only secure cookies (which is true by default),
@@ -65,9 +104,29 @@ func (c *cookieServer) SetCookieSecurity(secure bool) {
 	c.secure = secure
 }
 
+// SetStore replaces where the sessions are kept, which is in memory
+// unless this is called.  It should be called before the cookie server
+// is used.
+//
+// The ids of new guests carry on after the highest guest id in the
+// store, so that a guest whose cookie outlived a restart doesn't share
+// their id with a new one.
+func (c *cookieServer) SetStore(s registrar.Store) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reg = s
+	for _, id := range s.PlayerIDs() {
+		if id < accounts.FirstID && id > c.uniqueID {
+			c.uniqueID = id
+		}
+	}
+}
+
 // Returns a unique id that can be used to store a new player session.
-// Increments ids internally.
+// Increments ids internally.  Safe for concurrent use.
 func (c *cookieServer) nextUniqueID() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.uniqueID++
 	return c.uniqueID
 }
@@ -75,33 +134,40 @@ func (c *cookieServer) nextUniqueID() int {
 type userData struct {
 	ID      int
 	Name    string
+	Account bool
 	Token   []byte
+	Started time.Time
 	Expires time.Time
 }
 
 func (c *cookieServer) newUserData() userData {
+	now := time.Now()
 	return userData{
 		ID:      c.nextUniqueID(),
 		Name:    namegen.GenerateUsername(),
 		Token:   securecookie.GenerateRandomKey(32),
-		Expires: time.Now().Add(sessionDuration),
+		Started: now,
+		Expires: c.expiration(now, now),
 	}
 }
 
-// gimmeCookie randomly generates keys and returns a Secure Cookie.
-func gimmeCookie() *securecookie.SecureCookie {
-	hashKey := securecookie.GenerateRandomKey(hashKeyLen)
-	blockKey := securecookie.GenerateRandomKey(blockKeyLen)
-	// if hashKey == nil || blockKey == nil {
-	//	return nil, fmt.Errorf("GenerateRandomKey has returned nil.")
-	// }
-	return securecookie.New(hashKey, blockKey)
+// encode signs and encrypts a cookie value with the newest key.
+func (c *cookieServer) encode(v interface{}) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return securecookie.EncodeMulti(mainCookieName, v, c.codecs...)
 }
 
-// SetCookieHandler is called by the server to hand out cookies.
-func (c *cookieServer) setCookieHandler(w http.ResponseWriter, r *http.Request) {
-	v := c.newUserData()
-	encoded, err := c.gorillaSecureCookie.Encode(mainCookieName, v)
+// decodeValue reads a cookie value with whichever key it was made with.
+func (c *cookieServer) decodeValue(s string, v interface{}) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return securecookie.DecodeMulti(mainCookieName, s, v, c.codecs...)
+}
+
+// setCookie hands the client a cookie with the user's data.
+func (c *cookieServer) setCookie(w http.ResponseWriter, v userData, now time.Time) {
+	encoded, err := c.encode(v)
 	if err != nil {
 		log.Println(err)
 		return
@@ -110,43 +176,82 @@ func (c *cookieServer) setCookieHandler(w http.ResponseWriter, r *http.Request)
 		Name:   mainCookieName,
 		Value:  encoded,
 		Path:   "/",
-		MaxAge: maxAgeSeconds,
+		MaxAge: c.maxAge(v, now),
 		Secure: c.secure,
 	}
 	http.SetCookie(w, cookie)
+}
+
+// SetCookieHandler is called by the server to hand out cookies.
+func (c *cookieServer) setCookieHandler(w http.ResponseWriter, r *http.Request) {
+	c.startSession(w, c.newUserData())
+}
+
+// startSession adds a new session to the registrar, and hands the
+// client its cookie.
+func (c *cookieServer) startSession(w http.ResponseWriter, v userData) {
+	c.setCookie(w, v, v.Started)
 	user := registrar.User{
 		Name:  v.Name,
 		Token: v.Token,
 	}
 	session := registrar.UserSession{
 		User:       user,
-		Expiration: time.Now().Add(sessionDuration),
+		Expiration: v.Expires,
+		PlayerID:   v.ID,
 	}
 	c.reg.Add(session)
-	fmt.Fprintf(w, loginString, v.Name, v.ID, v.Token, sessionDuration)
+	fmt.Fprintf(w, loginString, v.Name, v.ID, v.Token, v.Expires.Sub(v.Started))
+}
+
+// decode reads the session cookie of a request, without checking if it
+// is still in the registrar.
+func (c *cookieServer) decode(r *http.Request) (userData, error) {
+	v := userData{}
+	cookie, err := r.Cookie(mainCookieName)
+	if err != nil {
+		return v, ErrNoSession
+	}
+	if err := c.decodeValue(cookie.Value, &v); err != nil {
+		log.Println(r.RemoteAddr, err)
+		return v, ErrInvalidSession
+	}
+	return v, nil
+}
+
+// Authenticate checks that a request carries a session cookie that was
+// handed out by this cookie server, and that the session hasn't expired
+// or been removed from the registrar.  The session is extended, and a
+// new cookie is written to w.
+func (c *cookieServer) Authenticate(w http.ResponseWriter, r *http.Request) (Session, error) {
+	v, err := c.decode(r)
+	if err != nil {
+		return Session{}, err
+	}
+	if !c.refresh(w, &v) {
+		return Session{}, ErrInvalidSession
+	}
+	return Session{
+		Name:     v.Name,
+		PlayerID: v.ID,
+		Account:  v.Account,
+		Started:  v.Started,
+		Expires:  v.Expires,
+		token:    v.Token,
+	}, nil
 }
 
 // ReadCookieHandler checks the client's cookies, and prints back a message if
 // it's valid.  Does not check yet check to see if the id matches the value that
-// it should; simply just confirms that it is a valid cookie.
+// it should; simply just confirms that it is a valid cookie.  A valid
+// session is extended, and the client gets a fresh cookie.
 func (c *cookieServer) readCookieHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err1 := r.Cookie(mainCookieName)
-	if err1 != nil {
-		c.setCookieHandler(w, r)
-		return
-	}
-	v := userData{}
-	err2 := c.gorillaSecureCookie.Decode(mainCookieName, cookie.Value, &v)
-	if err2 != nil {
-		log.Println(r.RemoteAddr, err2)
+	v, err := c.decode(r)
+	if err != nil {
 		c.setCookieHandler(w, r)
 		return
 	}
-	user := registrar.User{
-		Name:  v.Name,
-		Token: v.Token,
-	}
-	if c.reg.Validate(user) {
+	if c.refresh(w, &v) {
 		timeLeft := v.Expires.Sub(time.Now())
 		fmt.Fprintf(w, validString, v.Name, v.ID, v.Token, timeLeft)
 		return
@@ -175,5 +280,5 @@ func (c *cookieServer) ServeCookies(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *cookieServer) HandleInfo(w http.ResponseWriter, r *http.Request) {
-	c.reg.HandleInfo(w, r)
+	registrar.HandleInfo(c.reg, w, r)
 }
@@ -19,5 +19,20 @@ and a validation.
 
 If the playerid and the validation match, then it is assumed that the
 command originated from the same client that logged in.
+
+Websockets
+
+The cookie is sent along with the websocket handshake, so the server can
+check it with Authenticate before accepting the connection, and
+remember who is on the other end for as long as it stays open.
+
+Accounts
+
+Guests get a random name from /cookie, which is gone once the session
+ends.  Players can instead sign up with a username and password, which
+are checked by package accounts, and log in with them later.  Either
+way they are given the same kind of session cookie, so the rest of the
+server doesn't need to care which one it is, although Session.Account
+tells them apart.
 */
 package cookiez
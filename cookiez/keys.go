@@ -0,0 +1,175 @@
+package cookiez
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// KeysKept is how many keys the cookie server holds on to.  The newest
+// key signs the new cookies, and the older ones are only used to read
+// the cookies that were handed out before the last rotation.  Cookies
+// signed by a key that has been dropped stop working.
+var KeysKept = 2
+
+// KeyPair is a secret key for the session cookies.  The Hash key signs
+// a cookie, and the Block key encrypts it.
+type KeyPair struct {
+	Hash  []byte
+	Block []byte
+}
+
+// GenerateKeyPair makes a random KeyPair.
+func GenerateKeyPair() KeyPair {
+	return KeyPair{
+		Hash:  securecookie.GenerateRandomKey(hashKeyLen),
+		Block: securecookie.GenerateRandomKey(blockKeyLen),
+	}
+}
+
+// String writes the key pair as hex, like "hash:block", which is the
+// way ParseKeys reads it.
+func (k KeyPair) String() string {
+	return hex.EncodeToString(k.Hash) + ":" + hex.EncodeToString(k.Block)
+}
+
+// ParseKeys reads key pairs written like "hash:block" in hex, newest
+// first.  The pairs are separated by commas or whitespace, and lines
+// that start with # are ignored, so the same format works for a key
+// file or an environment variable.
+func ParseKeys(s string) ([]KeyPair, error) {
+	var keys []KeyPair
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})
+		for _, f := range fields {
+			k, err := parseKeyPair(f)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func parseKeyPair(s string) (KeyPair, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return KeyPair{}, fmt.Errorf("cookie key %.8s...: expected hash:block.", s)
+	}
+	hash, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("cookie key %.8s...: hash: %v", s, err)
+	}
+	block, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("cookie key %.8s...: block: %v", s, err)
+	}
+	if len(hash) != 32 && len(hash) != 64 {
+		return KeyPair{}, fmt.Errorf("cookie key %.8s...: the hash key must be 32 or 64 bytes.", s)
+	}
+	switch len(block) {
+	case 16, 24, 32:
+	default:
+		return KeyPair{}, fmt.Errorf("cookie key %.8s...: the block key must be 16, 24 or 32 bytes.", s)
+	}
+	return KeyPair{Hash: hash, Block: block}, nil
+}
+
+// LoadKeys reads the key pairs in a file.
+func LoadKeys(path string) ([]KeyPair, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeys(string(b))
+}
+
+// SaveKeys writes the key pairs to a file, newest first.  Only the
+// owner of the file can read it.
+func SaveKeys(path string, keys []KeyPair) error {
+	var b strings.Builder
+	b.WriteString("# tilegame cookie keys, newest first, as hash:block in hex.\n")
+	for _, k := range keys {
+		b.WriteString(k.String() + "\n")
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// SetKeys replaces the keys of the cookie server, newest first.  Only
+// KeysKept of them are used.  Without any keys, a random one is made.
+func (c *cookieServer) SetKeys(keys ...KeyPair) {
+	if len(keys) == 0 {
+		keys = []KeyPair{GenerateKeyPair()}
+	}
+	if len(keys) > KeysKept && KeysKept > 0 {
+		keys = keys[:KeysKept]
+	}
+	pairs := make([][]byte, 0, 2*len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k.Hash, k.Block)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys = append([]KeyPair(nil), keys...)
+	c.codecs = securecookie.CodecsFromPairs(pairs...)
+}
+
+// Keys returns the keys in use, newest first.
+func (c *cookieServer) Keys() []KeyPair {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]KeyPair(nil), c.keys...)
+}
+
+// UseKeyFile loads the keys of the cookie server from a file, so that
+// the cookies keep working after a restart, and can be shared between
+// servers.  If the file doesn't exist yet, it is made with a new key.
+// Keys made by RotateKey are saved to the same file.
+func (c *cookieServer) UseKeyFile(path string) error {
+	keys, err := LoadKeys(path)
+	if os.IsNotExist(err) {
+		keys = []KeyPair{GenerateKeyPair()}
+		err = SaveKeys(path, keys)
+	}
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%s: no cookie keys in the file.", path)
+	}
+	c.SetKeys(keys...)
+	c.mu.Lock()
+	c.keyFile = path
+	c.mu.Unlock()
+	return nil
+}
+
+// RotateKey makes a new key for signing cookies.  The previous keys
+// keep working for the cookies that are already out there, until they
+// are pushed out by KeysKept.  The new keys are saved to the key file,
+// if there is one.
+func (c *cookieServer) RotateKey() error {
+	keys := append([]KeyPair{GenerateKeyPair()}, c.Keys()...)
+	c.SetKeys(keys...)
+	c.mu.RLock()
+	path := c.keyFile
+	c.mu.RUnlock()
+	if path == "" {
+		return nil
+	}
+	return SaveKeys(path, c.Keys())
+}
@@ -0,0 +1,64 @@
+package cookiez
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	a, b := GenerateKeyPair(), GenerateKeyPair()
+	keys, err := ParseKeys("# a comment\n" + a.String() + ", " + b.String() + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []KeyPair{a, b}) {
+		t.Errorf("the keys didn't survive being written and read: %v", keys)
+	}
+
+	for _, bad := range []string{"abcd", "zz:zz", "abcd:abcd", a.String()[:64] + ":abcd"} {
+		if _, err := ParseKeys(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	c := NewCookieServer()
+	if err := c.UseKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	first, err := c.encode(userData{Name: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the old cookie still works after one rotation.
+	if err := c.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	var v userData
+	if err := c.decodeValue(first, &v); err != nil || v.Name != "alice" {
+		t.Errorf("the cookie stopped working after a rotation: %v", err)
+	}
+
+	// the keys are shared with a server that uses the same file.
+	other := NewCookieServer()
+	if err := other.UseKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := c.encode(userData{Name: "bob"})
+	if err := other.decodeValue(second, &v); err != nil || v.Name != "bob" {
+		t.Errorf("the other server can't read the cookie: %v", err)
+	}
+
+	// but not after the key is pushed out.
+	c.RotateKey()
+	if err := c.decodeValue(first, &v); err == nil {
+		t.Error("the cookie should have stopped working.")
+	}
+	if n := len(c.Keys()); n != KeysKept {
+		t.Errorf("expected %d keys, got %d", KeysKept, n)
+	}
+}
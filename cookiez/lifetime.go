@@ -0,0 +1,129 @@
+package cookiez
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/tilegame/gameserver/cookiez/registrar"
+)
+
+// Lifetime is how long the sessions last.  Sessions are sliding: every
+// visit to /cookie, and every Touch, pushes the end of the session
+// back to IdleTimeout from now, until it has lasted MaxLifetime.
+type Lifetime struct {
+	// Duration is how long the browser keeps the cookie each time it
+	// is handed out.
+	Duration time.Duration
+
+	// IdleTimeout is how long a session lasts without any activity.
+	IdleTimeout time.Duration
+
+	// MaxLifetime is how long a session can last after logging in, no
+	// matter how active it is.
+	MaxLifetime time.Duration
+}
+
+// DefaultLifetime is the Lifetime of the sessions of a new cookie
+// server.
+var DefaultLifetime = Lifetime{
+	Duration:    24 * time.Hour,
+	IdleTimeout: 30 * time.Minute,
+	MaxLifetime: 7 * 24 * time.Hour,
+}
+
+// SetLifetime changes how long new and refreshed sessions last.  Zero
+// fields are taken from the DefaultLifetime.  It should be called
+// before the cookie server is used.
+func (c *cookieServer) SetLifetime(l Lifetime) {
+	if l.Duration <= 0 {
+		l.Duration = DefaultLifetime.Duration
+	}
+	if l.IdleTimeout <= 0 {
+		l.IdleTimeout = DefaultLifetime.IdleTimeout
+	}
+	if l.MaxLifetime <= 0 {
+		l.MaxLifetime = DefaultLifetime.MaxLifetime
+	}
+	c.lifetime = l
+}
+
+// expiration is when a session that started at a given time ends, if
+// nothing happens after now.
+func (c *cookieServer) expiration(started, now time.Time) time.Time {
+	e := now.Add(c.lifetime.IdleTimeout)
+	if max := started.Add(c.lifetime.MaxLifetime); max.Before(e) {
+		e = max
+	}
+	return e
+}
+
+// maxAge is how many seconds the browser should keep a cookie, which
+// is never longer than the session can last.
+func (c *cookieServer) maxAge(v userData, now time.Time) int {
+	age := c.lifetime.Duration
+	if left := v.Started.Add(c.lifetime.MaxLifetime).Sub(now); left < age {
+		age = left
+	}
+	return int(age / time.Second)
+}
+
+// refresh checks that a session is still in the registrar, and extends
+// it.  When w isn't nil, the client is also given a new cookie with the
+// new expiration time.
+func (c *cookieServer) refresh(w http.ResponseWriter, v *userData) bool {
+	user := registrar.User{Name: v.Name, Token: v.Token}
+	if !c.reg.Validate(user) {
+		return false
+	}
+	now := time.Now()
+	v.Expires = c.expiration(v.Started, now)
+	if !v.Expires.After(now) {
+		c.reg.Remove(v.Name)
+		return false
+	}
+	c.reg.Add(registrar.UserSession{User: user, Expiration: v.Expires, PlayerID: v.ID})
+	if w != nil {
+		c.setCookie(w, *v, now)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, t := range c.touched {
+		if now.Sub(t) > c.lifetime.IdleTimeout {
+			delete(c.touched, key)
+		}
+	}
+	c.touched[touchKey{v.Name, string(v.Token)}] = now
+	return true
+}
+
+// Touch extends a session that is being used, like over a websocket
+// connection, which can't be given a new cookie.  ErrInvalidSession is
+// returned if the session has ended, or was removed or replaced.
+//
+// The session is checked every time, but to keep from saving it for
+// every message, it is only extended once it has gone a tenth of the
+// IdleTimeout without being refreshed.
+func (c *cookieServer) Touch(s Session) error {
+	key := touchKey{s.Name, string(s.token)}
+	if !c.reg.Validate(registrar.User{Name: s.Name, Token: s.token}) {
+		c.mu.Lock()
+		delete(c.touched, key)
+		c.mu.Unlock()
+		return ErrInvalidSession
+	}
+	now := time.Now()
+	c.mu.Lock()
+	last, ok := c.touched[key]
+	c.mu.Unlock()
+	if ok && now.Sub(last) < c.lifetime.IdleTimeout/10 {
+		return nil
+	}
+	v := userData{ID: s.PlayerID, Name: s.Name, Account: s.Account, Token: s.token, Started: s.Started}
+	if !c.refresh(nil, &v) {
+		c.mu.Lock()
+		delete(c.touched, key)
+		c.mu.Unlock()
+		return ErrInvalidSession
+	}
+	return nil
+}
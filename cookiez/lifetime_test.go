@@ -0,0 +1,162 @@
+package cookiez
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tilegame/gameserver/cookiez/registrar"
+)
+
+// login visits /cookie, and returns the cookie that was handed out.
+func login(t *testing.T, c *cookieServer, cookie *http.Cookie) *http.Cookie {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/cookie", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	c.ServeCookies(w, r)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a cookie, got %v", cookies)
+	}
+	return cookies[0]
+}
+
+func authenticate(c *cookieServer, cookie *http.Cookie) (Session, error) {
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.AddCookie(cookie)
+	return c.Authenticate(httptest.NewRecorder(), r)
+}
+
+func TestSlidingSessions(t *testing.T) {
+	c := NewCookieServer()
+	c.SetLifetime(Lifetime{
+		Duration:    time.Hour,
+		IdleTimeout: 100 * time.Millisecond,
+		MaxLifetime: 400 * time.Millisecond,
+	})
+
+	// visiting /cookie keeps the session going past the idle timeout.
+	cookie := login(t, c, nil)
+	for i := 0; i < 3; i++ {
+		time.Sleep(60 * time.Millisecond)
+		cookie = login(t, c, cookie)
+	}
+	s, err := authenticate(c, cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and so does using it, until the max lifetime.
+	for i := 0; i < 3; i++ {
+		time.Sleep(60 * time.Millisecond)
+		if err := c.Touch(s); err != nil {
+			t.Fatalf("touch %d: %v", i, err)
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := c.Touch(s); err != ErrInvalidSession {
+		t.Errorf("expected the session to have ended, got %v", err)
+	}
+}
+
+// TestTouchRemovedSession checks that a session stops working as soon
+// as it is removed, even though it was refreshed a moment ago.
+func TestTouchRemovedSession(t *testing.T) {
+	c := NewCookieServer()
+	s, err := authenticate(c, login(t, c, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Touch(s); err != nil {
+		t.Fatal(err)
+	}
+	c.reg.Remove(s.Name)
+	if err := c.Touch(s); err != ErrInvalidSession {
+		t.Errorf("expected the removed session to have ended, got %v", err)
+	}
+}
+
+func TestCookieMaxAge(t *testing.T) {
+	c := NewCookieServer()
+	c.SetLifetime(Lifetime{Duration: time.Hour, MaxLifetime: 10 * time.Second})
+	if age := login(t, c, nil).MaxAge; age <= 0 || age > 10 {
+		t.Errorf("the cookie should last until the end of the session, but has a MaxAge of %d", age)
+	}
+	c.SetLifetime(Lifetime{Duration: 5 * time.Second})
+	if age := login(t, c, nil).MaxAge; age != 5 {
+		t.Errorf("expected a MaxAge of 5, got %d", age)
+	}
+}
+
+func TestIdleSession(t *testing.T) {
+	c := NewCookieServer()
+	c.SetLifetime(Lifetime{IdleTimeout: 20 * time.Millisecond})
+	cookie := login(t, c, nil)
+	time.Sleep(60 * time.Millisecond)
+	if _, err := authenticate(c, cookie); err != ErrInvalidSession {
+		t.Errorf("expected ErrInvalidSession, got %v", err)
+	}
+	if _, err := authenticate(c, &http.Cookie{Name: "other", Value: "x"}); err != ErrNoSession {
+		t.Errorf("expected ErrNoSession, got %v", err)
+	}
+}
+
+// TestGuestIDsAfterRestart checks that a new guest isn't given the id
+// of a guest whose session was saved before the server restarted.
+func TestGuestIDsAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions")
+	keys := []KeyPair{GenerateKeyPair()}
+	start := func() (*cookieServer, *registrar.FileStore) {
+		store, err := registrar.OpenFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewCookieServer()
+		c.SetKeys(keys...)
+		c.SetStore(store)
+		return c, store
+	}
+
+	c, store := start()
+	login(t, c, nil)
+	old, err := authenticate(c, login(t, c, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	c, store = start()
+	defer store.Close()
+	s, err := authenticate(c, login(t, c, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.PlayerID <= old.PlayerID {
+		t.Errorf("the new guest got id %d, after %d was handed out", s.PlayerID, old.PlayerID)
+	}
+
+	// ids handed out at the same time are still unique.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := map[int]bool{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := c.nextUniqueID()
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[id] {
+				t.Errorf("id %d was handed out twice", id)
+			}
+			seen[id] = true
+		}()
+	}
+	wg.Wait()
+}
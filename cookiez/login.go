@@ -0,0 +1,85 @@
+package cookiez
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/tilegame/gameserver/cookiez/accounts"
+)
+
+// SetAccounts replaces where the registered accounts are kept, which is
+// in memory unless this is called.  It should be called before the
+// cookie server is used.
+func (c *cookieServer) SetAccounts(s *accounts.Store) {
+	c.accounts = s
+}
+
+// ServeSignup makes a new account from the "username" and "password" of
+// a POSTed form, and logs in to it.  Names that are taken, or that
+// aren't allowed, are turned away.
+func (c *cookieServer) ServeSignup(w http.ResponseWriter, r *http.Request) {
+	name, password, ok := loginForm(w, r)
+	if !ok {
+		return
+	}
+	a, err := c.accounts.SignUp(name, password)
+	switch err {
+	case nil:
+		log.Printf("%s signed up as %s (%d)", r.RemoteAddr, a.Name, a.ID)
+		c.startSession(w, c.accountUserData(a))
+	case accounts.ErrNameTaken:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case accounts.ErrBadName, accounts.ErrShortPassword, accounts.ErrLongPassword:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Println("signup:", err)
+		http.Error(w, "unable to make the account.", http.StatusInternalServerError)
+	}
+}
+
+// ServeLogin logs in to an account with the "username" and "password"
+// of a POSTed form.  The client gets the same kind of session cookie
+// that guests get from /cookie.
+func (c *cookieServer) ServeLogin(w http.ResponseWriter, r *http.Request) {
+	name, password, ok := loginForm(w, r)
+	if !ok {
+		return
+	}
+	a, err := c.accounts.Login(name, password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	c.startSession(w, c.accountUserData(a))
+}
+
+// loginForm reads the username and password that were sent, and
+// answers the request itself if they can't be read.
+func loginForm(w http.ResponseWriter, r *http.Request) (name, password string, ok bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST with a username and password.", http.StatusMethodNotAllowed)
+		return "", "", false
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", "", false
+	}
+	return r.PostForm.Get("username"), r.PostForm.Get("password"), true
+}
+
+// accountUserData starts a new session for an account.  The player id
+// is the account's, so it stays the same every time they log in.
+func (c *cookieServer) accountUserData(a accounts.Account) userData {
+	now := time.Now()
+	return userData{
+		ID:      a.ID,
+		Name:    a.Name,
+		Account: true,
+		Token:   securecookie.GenerateRandomKey(32),
+		Started: now,
+		Expires: c.expiration(now, now),
+	}
+}
@@ -0,0 +1,89 @@
+package cookiez
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/tilegame/gameserver/cookiez/accounts"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func postForm(handler http.HandlerFunc, name, password string) *http.Response {
+	form := url.Values{"username": {name}, "password": {password}}
+	r := httptest.NewRequest("POST", "/", nil)
+	r.PostForm = form
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w.Result()
+}
+
+func TestSignupAndLogin(t *testing.T) {
+	accounts.Cost = bcrypt.MinCost
+	c := NewCookieServer()
+
+	res := postForm(c.ServeSignup, "alice", "correct horse")
+	if res.StatusCode != http.StatusOK || len(res.Cookies()) != 1 {
+		t.Fatalf("signup: got %s with cookies %v", res.Status, res.Cookies())
+	}
+	s, err := authenticate(c, res.Cookies()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "alice" || !s.Account {
+		t.Errorf("expected alice's account, got %+v", s)
+	}
+
+	res = postForm(c.ServeLogin, "Alice", "correct horse")
+	if res.StatusCode != http.StatusOK || len(res.Cookies()) != 1 {
+		t.Fatalf("login: got %s with cookies %v", res.Status, res.Cookies())
+	}
+	s2, err := authenticate(c, res.Cookies()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2.PlayerID != s.PlayerID {
+		t.Errorf("expected the same player id %d, got %d", s.PlayerID, s2.PlayerID)
+	}
+
+	// logging in again replaces the first session.
+	if err := c.Touch(s); err != ErrInvalidSession {
+		t.Errorf("expected the replaced session to have ended, got %v", err)
+	}
+	if err := c.Touch(s2); err != nil {
+		t.Errorf("expected the new session to work, got %v", err)
+	}
+
+	cases := []struct {
+		handler        http.HandlerFunc
+		name, password string
+		status         int
+	}{
+		{c.ServeSignup, "ALICE", "battery staple", http.StatusConflict},
+		{c.ServeSignup, "a", "battery staple", http.StatusBadRequest},
+		{c.ServeSignup, "bob", "short", http.StatusBadRequest},
+		{c.ServeLogin, "alice", "battery staple", http.StatusUnauthorized},
+		{c.ServeLogin, "nobody", "correct horse", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		res := postForm(tc.handler, tc.name, tc.password)
+		if res.StatusCode != tc.status || len(res.Cookies()) != 0 {
+			t.Errorf("%s/%s: expected %d and no cookie, got %s with %v",
+				tc.name, tc.password, tc.status, res.Status, res.Cookies())
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/login", nil)
+	w := httptest.NewRecorder()
+	c.ServeLogin(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /login: expected 405, got %d", w.Code)
+	}
+
+	// guests can still play.
+	guest, err := authenticate(c, login(t, c, nil))
+	if err != nil || guest.Account {
+		t.Errorf("expected a guest session, got %+v, %v", guest, err)
+	}
+}
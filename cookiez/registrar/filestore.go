@@ -0,0 +1,199 @@
+package registrar
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// compactSlack is how many extra records the log of a FileStore can
+// have before it is rewritten.
+const compactSlack = 64
+
+// FileStore is a Store that keeps the sessions in memory, and also
+// writes every change to an append-only log file.  When the file is
+// opened again, the log is replayed, so the sessions last through a
+// restart of the server.
+//
+// The log is one JSON record per line.  It is rewritten with only the
+// active sessions when it is opened, and whenever it has grown to
+// twice as many records as there are sessions.  Errors writing to the
+// file are logged, and don't stop the sessions from working.
+type FileStore struct {
+	mem *Registrar
+
+	mu      sync.Mutex // guards the file, and the order of the changes.
+	path    string
+	file    *os.File
+	records int
+}
+
+// record is a single change in the log of a FileStore.
+type record struct {
+	Op         string    `json:"op"` // "add" or "remove".
+	Name       string    `json:"name"`
+	Token      []byte    `json:"token,omitempty"`
+	Expiration time.Time `json:"expiration"`
+	PlayerID   int       `json:"id,omitempty"`
+}
+
+// OpenFile opens the FileStore saved at path, or creates a new one if
+// the file doesn't exist.  Lines of the log that can't be read, like a
+// record that was half written when the server stopped, are skipped.
+func OpenFile(path string) (*FileStore, error) {
+	s := &FileStore{mem: NewRegistrar(), path: path}
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		s.replay(f)
+		f.Close()
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) replay(f *os.File) {
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			log.Printf("Registrar: %s:%d: %v", s.path, line, err)
+			continue
+		}
+		switch r.Op {
+		case "add":
+			s.mem.Add(UserSession{User{r.Name, r.Token}, r.Expiration, r.PlayerID})
+		case "remove":
+			s.mem.Remove(r.Name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Registrar: %s: %v", s.path, err)
+	}
+}
+
+// Add creates or replaces the session of a user, and saves it.
+func (s *FileStore) Add(session UserSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.Add(session)
+	s.write(record{"add", session.Name, session.Token, session.Expiration, session.PlayerID})
+}
+
+// Validate checks that the user's token matches their session, and
+// that the session hasn't expired.
+func (s *FileStore) Validate(user User) bool {
+	return s.mem.Validate(user)
+}
+
+// Remove deletes the session of a user, and saves that it is gone.
+func (s *FileStore) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.Remove(name)
+	s.write(record{Op: "remove", Name: name})
+}
+
+// Clean deletes the sessions that have expired.  They don't need to be
+// written down, since expired sessions are dropped when the log is
+// read anyway.
+func (s *FileStore) Clean() {
+	s.mem.Clean()
+}
+
+// List returns when each of the active sessions expires, by username.
+func (s *FileStore) List() map[string]time.Time {
+	return s.mem.List()
+}
+
+// PlayerIDs returns the player id of each active session, by username.
+func (s *FileStore) PlayerIDs() map[string]int {
+	return s.mem.PlayerIDs()
+}
+
+// Close closes the log file.  The FileStore shouldn't be used
+// afterwords.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// write appends a record to the log, and rewrites the log once it has
+// too many records.  The caller holds the lock.
+func (s *FileStore) write(r record) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		log.Println("Registrar:", err)
+		return
+	}
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		log.Printf("Registrar: %s: %v", s.path, err)
+		return
+	}
+	s.records++
+	if s.records > 2*len(s.mem.List())+compactSlack {
+		if err := s.compact(); err != nil {
+			log.Printf("Registrar: %s: %v", s.path, err)
+		}
+	}
+}
+
+// compact rewrites the log with a single record for each active
+// session, and opens it for appending.  The new log replaces the old
+// one all at once, so a crash in the middle leaves the old one alone.
+// The caller holds the lock.
+func (s *FileStore) compact() error {
+	s.mem.Clean()
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	n := 0
+	s.mem.mutex.Lock()
+	for name, sesh := range s.mem.userMap {
+		if err = enc.Encode(record{"add", name, sesh.token, sesh.expiration, sesh.playerID}); err != nil {
+			break
+		}
+		n++
+	}
+	s.mem.mutex.Unlock()
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, s.records = file, n
+	return nil
+}
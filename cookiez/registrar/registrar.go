@@ -10,9 +10,35 @@ import (
 	"time"
 )
 
+// Store keeps the user sessions.  The methods of a Store are safe for
+// concurrent use.  Registrar keeps them in memory, and FileStore also
+// saves them to a file, so that they last through a restart.
+type Store interface {
+	// Add creates or replaces the session of a user.
+	Add(session UserSession)
+
+	// Validate checks that the user's token matches their session,
+	// and that the session hasn't expired.
+	Validate(user User) bool
+
+	// Remove deletes the session of a user.
+	Remove(name string)
+
+	// Clean deletes the sessions that have expired.
+	Clean()
+
+	// List returns when each of the active sessions expires, by
+	// username.
+	List() map[string]time.Time
+
+	// PlayerIDs returns the player id of each of the active sessions,
+	// by username.
+	PlayerIDs() map[string]int
+}
+
 // Registrar is the main object contains a hash map to store the user
 // sessions, and several channels which allow it to be accessed
-// concurrently.
+// concurrently.  It is the in-memory Store.
 type Registrar struct {
 	userMap map[string]savedSession
 	mutex   sync.Mutex
@@ -23,6 +49,7 @@ type Registrar struct {
 type savedSession struct {
 	token      []byte
 	expiration time.Time
+	playerID   int
 }
 
 // User consists of a username and it's secret token, it can be passed
@@ -37,9 +64,13 @@ type User struct {
 // passed to the AddUser() function.  When Validate() is called, the
 // expiration time is checked.  If the current time is past the
 // expiration time, then Validate() returns false.
+//
+// PlayerID is kept along with the session, so that the ids of the
+// active sessions can be found again after a restart.
 type UserSession struct {
 	User
 	Expiration time.Time
+	PlayerID   int
 }
 
 // Info is for checking on the status of the registrar.  It will
@@ -92,6 +123,7 @@ func (r *Registrar) Add(session UserSession) {
 	r.userMap[session.Name] = savedSession{
 		token:      session.Token,
 		expiration: session.Expiration,
+		playerID:   session.PlayerID,
 	}
 }
 
@@ -118,28 +150,63 @@ func (r *Registrar) Clean() {
 	}
 }
 
-// GenerateInfo returns an Info object with information about the
-// registrar.  This information can be used in a webpage, turned into
-// a JSON, etc.  Does not include the token of any user.
-func (r *Registrar) GenerateInfo() *Info {
-	// clean the usermap before generating any info.
+// List returns when each of the active sessions expires, by username.
+// Safe for concurrent use.
+func (r *Registrar) List() map[string]time.Time {
+	// clean the usermap before listing anyone.
 	r.Clean()
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	info := &Info{
-		ActiveSessions: len(r.userMap),
-		SessionDetails: make(map[string]time.Duration),
+	list := make(map[string]time.Time, len(r.userMap))
+	for name, sesh := range r.userMap {
+		list[name] = sesh.expiration
 	}
+	return list
+}
+
+// PlayerIDs returns the player id of each active session, by username.
+// Safe for concurrent use.
+func (r *Registrar) PlayerIDs() map[string]int {
+	r.Clean()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ids := make(map[string]int, len(r.userMap))
 	for name, sesh := range r.userMap {
-		info.SessionDetails[name] = sesh.expiration.Sub(time.Now())
+		ids[name] = sesh.playerID
 	}
-	return info
+	return ids
+}
+
+// GenerateInfo returns an Info object with information about the
+// registrar.  This information can be used in a webpage, turned into
+// a JSON, etc.  Does not include the token of any user.
+func (r *Registrar) GenerateInfo() *Info {
+	return GenerateInfo(r)
 }
 
 // HandleInfo returns a webpage with information about the currently
 // active sesions
 func (r *Registrar) HandleInfo(w http.ResponseWriter, req *http.Request) {
-	msg, err := json.MarshalIndent(r.GenerateInfo(), "", "\t")
+	HandleInfo(r, w, req)
+}
+
+// GenerateInfo returns an Info object with information about the
+// sessions in a Store.  Does not include the token of any user.
+func GenerateInfo(s Store) *Info {
+	list := s.List()
+	info := &Info{
+		ActiveSessions: len(list),
+		SessionDetails: make(map[string]time.Duration),
+	}
+	for name, expiration := range list {
+		info.SessionDetails[name] = expiration.Sub(time.Now())
+	}
+	return info
+}
+
+// HandleInfo writes the Info of a Store as JSON.
+func HandleInfo(s Store, w http.ResponseWriter, req *http.Request) {
+	msg, err := json.MarshalIndent(GenerateInfo(s), "", "\t")
 
 	if err != nil {
 		log.Println("Registrar:", err)
@@ -1,6 +1,7 @@
 package registrar
 
 import (
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -8,7 +9,6 @@ import (
 /*
 
 TODO
- - test that listing info works correctly in different scenarios.
  - test for concurrent adds, removes, and listing.
 
 */
@@ -16,46 +16,76 @@ TODO
 const expireDuration = 100 * time.Millisecond
 
 var (
-	name       = "testUser123"
-	token      = []byte("arbitrary sequence of bytes.")
-	expiration = time.Now().Add(expireDuration)
-	user       = User{name, token}
-	session    = UserSession{user, expiration}
+	name  = "testUser123"
+	token = []byte("arbitrary sequence of bytes.")
+	user  = User{name, token}
 )
 
+// newSession makes a session for the test user that expires soon.
+func newSession() UserSession {
+	return UserSession{user, time.Now().Add(expireDuration), 124}
+}
+
+// backends are the kinds of Store that every test is run against.
+var backends = map[string]func(t *testing.T) Store{
+	"memory": func(t *testing.T) Store {
+		return NewRegistrar()
+	},
+	"file": func(t *testing.T) Store {
+		s, err := OpenFile(filepath.Join(t.TempDir(), "sessions"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	},
+}
+
+// forEachStore runs a test against each of the backends.
+func forEachStore(t *testing.T, test func(t *testing.T, r Store)) {
+	for name, open := range backends {
+		open := open
+		t.Run(name, func(t *testing.T) {
+			test(t, open(t))
+		})
+	}
+}
+
 // Test the most simplistic form of the APIs.
 func TestBasicAPI(t *testing.T) {
-	r := NewRegistrar()
-	r.Add(session)
-	r.Remove(name)
+	forEachStore(t, func(t *testing.T, r Store) {
+		r.Add(newSession())
+		r.Remove(name)
+	})
 }
 
 func TestValidate(t *testing.T) {
-	r := NewRegistrar()
-	r.Add(session)
+	forEachStore(t, func(t *testing.T, r Store) {
+		r.Add(newSession())
 
-	// Try to validate a real user who was just added to the
-	// registar.
-	if !r.Validate(user) {
-		t.Error("unable to validate a valid user!")
-	}
-	t.Log("Valid User was successfully reported Valid.")
+		// Try to validate a real user who was just added to the
+		// registar.
+		if !r.Validate(user) {
+			t.Error("unable to validate a valid user!")
+		}
+		t.Log("Valid User was successfully reported Valid.")
 
-	// Try to validate a fake user who wasn't added to the
-	// registar.
-	fake := User{"invalid user", []byte("lolzors")}
-	if r.Validate(fake) {
-		t.Error("A fake user was validated, but was never added!")
-	}
-	t.Log("Invalid User was successfully reported Invalid..")
+		// Try to validate a fake user who wasn't added to the
+		// registar.
+		fake := User{"invalid user", []byte("lolzors")}
+		if r.Validate(fake) {
+			t.Error("A fake user was validated, but was never added!")
+		}
+		t.Log("Invalid User was successfully reported Invalid..")
 
-	// Remove the user from the registrar, then try to validate
-	// Expected behavior: user not valid.
-	r.Remove(user.Name)
-	if r.Validate(user) {
-		t.Error("the user was removed, but still was validated!")
-	}
-	t.Log("user successfully removed, and then Not validated.")
+		// Remove the user from the registrar, then try to validate
+		// Expected behavior: user not valid.
+		r.Remove(user.Name)
+		if r.Validate(user) {
+			t.Error("the user was removed, but still was validated!")
+		}
+		t.Log("user successfully removed, and then Not validated.")
+	})
 }
 
 // TestExpiration adds a user, then waits until the expiration of that
@@ -63,11 +93,78 @@ func TestValidate(t *testing.T) {
 // passes if they correctly aren't reported Invalid after the
 // expiration time has passed.
 func TestExpiration(t *testing.T) {
-	r := NewRegistrar()
-	r.Add(session)
-	time.Sleep(expireDuration)
-	if r.Validate(user) {
-		t.Error("The token has expired, but the user was still validated!")
+	forEachStore(t, func(t *testing.T, r Store) {
+		r.Add(newSession())
+		time.Sleep(expireDuration)
+		if r.Validate(user) {
+			t.Error("The token has expired, but the user was still validated!")
+		}
+		t.Log("The token expired, and the user was succesfully reported Invalid.")
+	})
+}
+
+func TestList(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Store) {
+		later := time.Now().Add(time.Hour)
+		r.Add(UserSession{User{"alice", token}, later, 124})
+		r.Add(UserSession{User{"bob", token}, time.Now().Add(-time.Second), 125})
+		r.Add(UserSession{User{"carol", token}, later, 126})
+		r.Remove("carol")
+
+		list := r.List()
+		if len(list) != 1 || !list["alice"].Equal(later) {
+			t.Errorf("expected only alice, got %v", list)
+		}
+		if ids := r.PlayerIDs(); len(ids) != 1 || ids["alice"] != 124 {
+			t.Errorf("expected only alice's id, got %v", ids)
+		}
+		if info := GenerateInfo(r); info.ActiveSessions != 1 {
+			t.Errorf("expected 1 active session, got %d", info.ActiveSessions)
+		}
+	})
+}
+
+// TestFileStoreReopen checks that the sessions in a FileStore are still
+// there when it is opened again.
+func TestFileStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	s.Add(UserSession{User{"alice", []byte("a")}, later, 124})
+	s.Add(UserSession{User{"bob", []byte("b")}, later, 125})
+	s.Add(UserSession{User{"alice", []byte("c")}, later, 124})
+	s.Remove("bob")
+
+	// enough changes to rewrite the log a few times.
+	for i := 0; i < 3*compactSlack; i++ {
+		s.Add(UserSession{User{"carol", []byte{byte(i)}}, later, 1000000})
+	}
+	s.Close()
+
+	s, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !s.Validate(User{"alice", []byte("c")}) {
+		t.Error("alice's latest session was lost.")
+	}
+	if s.Validate(User{"alice", []byte("a")}) {
+		t.Error("alice's old token still works.")
+	}
+	if s.Validate(User{"bob", []byte("b")}) {
+		t.Error("bob was removed, but came back.")
+	}
+	if !s.Validate(User{"carol", []byte{3*compactSlack - 1}}) {
+		t.Error("carol's latest session was lost.")
+	}
+	if ids := s.PlayerIDs(); len(ids) != 2 || ids["alice"] != 124 || ids["carol"] != 1000000 {
+		t.Errorf("expected the player ids of alice and carol, got %v", ids)
+	}
+	if s.records != 2 {
+		t.Errorf("expected the log to be compacted to 2 records, found %d", s.records)
 	}
-	t.Log("The token expired, and the user was succesfully reported Invalid.")
 }
@@ -2,21 +2,50 @@
 package echoserver
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
+	"github.com/tilegame/gameserver/codec"
+	"github.com/tilegame/gameserver/gamestate"
 	"log"
 	"net/http"
+	"reflect"
+	"runtime/debug"
 	"sync"
-	"time"
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: codec.Subprotocols(),
 }
 
 type client struct {
-	conn *websocket.Conn
+	conn  *websocket.Conn
+	codec codec.Codec
+	mux   sync.Mutex
+}
+
+// write sends a message to the client, encoded with the client's
+// codec.
+func (c *client) write(v interface{}) error {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeEncoded(data)
+}
+
+// writeEncoded sends a message that is already encoded with the
+// client's codec.  The websocket connection only allows one writer at a
+// time, and the broadcaster writes from its own goroutine, so writes
+// are guarded by a mutex.
+func (c *client) writeEncoded(data []byte) error {
+	messageType := websocket.TextMessage
+	if c.codec.Binary() {
+		messageType = websocket.BinaryMessage
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.conn.WriteMessage(messageType, data)
 }
 
 type ActiveClientStore struct {
@@ -24,11 +53,54 @@ type ActiveClientStore struct {
 	mux       sync.Mutex
 }
 
-var clientlist = map[*client]bool{}
+func (s *ActiveClientStore) add(c *client) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.clientmap[c] = true
+}
+
+func (s *ActiveClientStore) remove(c *client) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.clientmap, c)
+}
+
+func (s *ActiveClientStore) len() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.clientmap)
+}
+
+// list returns the clients, so that they can be written to without
+// holding the lock.
+func (s *ActiveClientStore) list() []*client {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	out := make([]*client, 0, len(s.clientmap))
+	for c := range s.clientmap {
+		out = append(out, c)
+	}
+	return out
+}
+
+var clientlist = &ActiveClientStore{clientmap: map[*client]bool{}}
 
-func broadcast(data []byte) {
-	for client := range clientlist {
-		client.conn.WriteMessage(websocket.TextMessage, data)
+// broadcast sends a message to every client.  It is encoded once for
+// each codec that the clients use.
+func broadcast(v interface{}) {
+	encoded := map[codec.Codec][]byte{}
+	for _, client := range clientlist.list() {
+		b, ok := encoded[client.codec]
+		if !ok {
+			var err error
+			if b, err = client.codec.Marshal(v); err != nil {
+				log.Printf("broadcasting as %s: %v", client.codec.Name(), err)
+			}
+			encoded[client.codec] = b
+		}
+		if b != nil {
+			client.writeEncoded(b)
+		}
 	}
 }
 
@@ -40,31 +112,53 @@ func HandleWs(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	// create the client object and add it to the list.
+	// create the client object and add it to the list.  The client
+	// can ask for a binary codec as the websocket subprotocol.
+	cd, ok := codec.Lookup(conn.Subprotocol())
+	if !ok {
+		cd = codec.JSON
+	}
 	me := &client{
-		conn: conn,
+		conn:  conn,
+		codec: cd,
 	}
-	clientlist[me] = true
+	clientlist.add(me)
 
 	// Remove self from the client list when function returns.
 	defer func() {
-		delete(clientlist, me)
+		clientlist.remove(me)
 		conn.Close()
 	}()
 
+	// Send the tile map, so the client knows what the world looks like.
+	err = me.write(mapMessage())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
 	// wait for new incoming messages.
 	for {
-		messageType, message, err := conn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
 			log.Println(err)
 			break
 		}
 
-		// Process the message and replace it with a response.
-		message = send(message)
+		// Process the message, which is encoded with the client's
+		// codec, and answer it.  Badly formatted messages are
+		// answered with an error, instead of leaving the client
+		// waiting.
+		var result ResultMessage
+		j := new(IncomingMessage)
+		if err := me.codec.Unmarshal(message, j); err != nil {
+			result = ResultMessage{Error: "invalid message: " + err.Error()}
+		} else {
+			result = send(*j)
+		}
 
 		// Send the response back.
-		err = conn.WriteMessage(messageType, message)
+		err = me.write(result)
 		if err != nil {
 			log.Println(err)
 			break
@@ -75,33 +169,22 @@ func HandleWs(w http.ResponseWriter, r *http.Request) {
 
 // ~~~~~~~~~~~~~~~~~~ Game ~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 
-// TODO: move these out of this file, and make a seperate file for the
-// state of the game.  Variables related to the game.
-var (
-	nextPlayerId  = 136
-	playerlist    = map[string]*Player{}
-	activeplayers = map[string]bool{}
-
-	TICK_DURATION               = time.Millisecond * 500
-	PLAYERLIST_REFRESH_DURATION = 3 * time.Minute
-	StartTickerChan             = make(chan bool)
-	StopTickerChan              = make(chan bool)
-)
+// game holds the state of the world.  It is set by Start.
+var game *gamestate.Game
 
-func init() {
+// Start connects the echo server to a game, and starts the goroutines
+// that handle incoming messages and broadcast the player list after
+// every tick.  It must be called once, before any clients connect.
+func Start(g *gamestate.Game) {
+	game = g
 	go runPostOffice()
-	go runGameTicker()
-}
-
-func getNextPlayerId() int {
-	nextPlayerId++
-	return nextPlayerId
+	go runBroadcaster(g.Subscribe())
 }
 
 type IncomingMessage struct {
-	ID     int
-	Method string
-	Params []interface{}
+	ID     int           `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
 }
 
 type ResultMessage struct {
@@ -113,15 +196,15 @@ type ResultMessage struct {
 }
 
 type postcard struct {
-	data []byte
-	ret  chan []byte
+	message IncomingMessage
+	ret     chan ResultMessage
 }
 
-func send(data []byte) []byte {
-	myMailbox := make(chan []byte)
+func send(message IncomingMessage) ResultMessage {
+	myMailbox := make(chan ResultMessage)
 	card := postcard{
-		data: data,
-		ret:  myMailbox,
+		message: message,
+		ret:     myMailbox,
 	}
 	postoffice <- card
 	answer := <-myMailbox
@@ -137,37 +220,33 @@ func runPostOffice() {
 	for {
 		select {
 		case m := <-postoffice:
-			m.ret <- handleMessage(m.data)
+			m.ret <- handleMessage(m.message)
 		}
 	}
 }
 
-func handleMessage(data []byte) []byte {
-
-	// Incoming Bytes -> Incoming Json
-	j := new(IncomingMessage)
-	err := json.Unmarshal(data, j)
+func handleMessage(j IncomingMessage) ResultMessage {
 
-	// TODO: Handle badly formatted json by sending back an error.
-	if err != nil {
-		return []byte{}
-	}
+	// Pass the Incoming Message to the Command Handler.  That
+	// command handler returns a Result.
+	result := handleCommandSafely(j.Method, j.Params)
 
-	// Pass the Incoming Json to the Command Handler.  That
-	// command handler returns a Result Json.
-	result := handleCommand(j.Method, j.Params)
-
-	// Add the message id number to the Result Json.  This allows
-	// the client to identify their Json again.
+	// Add the message id number to the Result.  This allows
+	// the client to identify their message again.
 	result.ID = j.ID
+	return result
+}
 
-	// Convert Result Json back into bytes so that it can be sent
-	// back to the client.
-	outbytes, err := json.Marshal(result)
-	if err != nil {
-		log.Println(err)
-	}
-	return outbytes
+// handleCommandSafely recovers from a panic in handleCommand, so that
+// one bad message can't stop the post office for everyone.
+func handleCommandSafely(cmd string, params []interface{}) (result ResultMessage) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("command %s panicked: %v\n%s", cmd, v, debug.Stack())
+			result = ResultMessage{Error: fmt.Sprintf("command %s failed.", cmd)}
+		}
+	}()
+	return handleCommand(cmd, params)
 }
 
 // Where all the magic happens.  Accepts a command, does something,
@@ -196,7 +275,11 @@ func handleCommand(cmd string, params []interface{}) ResultMessage {
 
 	case "list":
 		out.Kind = "playerlist"
-		out.Result = playerlist
+		out.Result = game.Snapshot().Players
+
+	case "map":
+		out.Kind = "map"
+		out.Result = game.Snapshot().Map
 
 	case "chat":
 		doChatCmd(params, &out)
@@ -205,9 +288,7 @@ func handleCommand(cmd string, params []interface{}) ResultMessage {
 		return doMoveCmd(params)
 
 	case "update":
-		for _, p := range playerlist {
-			p.UpdatePosition()
-		}
+		game.Step()
 		out.Result = true
 
 	default:
@@ -216,7 +297,7 @@ func handleCommand(cmd string, params []interface{}) ResultMessage {
 	return out
 }
 
-// Adds a player to the playerlist.
+// Adds a player to the game.
 func doAddCmd(params []interface{}) ResultMessage {
 	out := ResultMessage{}
 
@@ -233,14 +314,13 @@ func doAddCmd(params []interface{}) ResultMessage {
 		return out
 	}
 
-	// add the player to the playerlist.
-	playerlist[name] = &Player{
-		PlayerId:   getNextPlayerId(),
-		CurrentPos: Loc{5, 5},
-		TargetPos:  Loc{5, 5},
+	// add the player to the game.
+	_, err := game.AddPlayer(name)
+	if err != nil {
+		out.Error = err.Error()
+		return out
 	}
 	out.Result = true
-	setPlayerToActive(name)
 	return out
 }
 
@@ -254,7 +334,10 @@ func handleRemove(params []interface{}, out *ResultMessage) {
 		out.Error = "type error"
 		return
 	}
-	delete(playerlist, name)
+	if err := game.RemovePlayer(name); err != nil {
+		out.Error = err.Error()
+		return
+	}
 	out.Result = true
 }
 
@@ -269,28 +352,39 @@ func doMoveCmd(params []interface{}) ResultMessage {
 
 	// convert types.
 	name, ok1 := params[0].(string)
-	x, ok2 := params[1].(float64)
-	y, ok3 := params[2].(float64)
+	x, ok2 := number(params[1])
+	y, ok3 := number(params[2])
 	if !(ok1 && ok2 && ok3) {
 		out.Error = "type error: expected (string, int, int)"
 		return out
 	}
 
-	// retrieve player pointer.
-	p, ok4 := playerlist[name]
-	if !ok4 {
-		out.Error = "player does not exist."
+	// find a path to the target, and start walking.
+	err := game.MovePlayer(name, int(x), int(y))
+	if err != nil {
+		out.Error = err.Error()
 		return out
 	}
-
-	// update positions and return successfull.
-	p.TargetPos.X = int(x)
-	p.TargetPos.Y = int(y)
 	out.Result = true
-	setPlayerToActive(name)
 	return out
 }
 
+// number reads a number parameter.  JSON numbers are always float64,
+// but the binary codecs decode whole numbers as integers.
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int, int8, int16, int32, int64:
+		return float64(reflect.ValueOf(n).Int()), true
+	case uint, uint8, uint16, uint32, uint64:
+		return float64(reflect.ValueOf(n).Uint()), true
+	}
+	return 0, false
+}
+
 func doChatCmd(params []interface{}, out *ResultMessage) {
 	if len(params) != 2 {
 		out.Error = "expected 2 params: (username, chatmessage)"
@@ -302,7 +396,7 @@ func doChatCmd(params []interface{}, out *ResultMessage) {
 		out.Error = "type error: expected (string, string)"
 		return
 	}
-	if _, ok := playerlist[name]; !ok {
+	if _, ok := game.Player(name); !ok {
 		out.Error = "player does not exist."
 		return
 	}
@@ -313,127 +407,23 @@ func doChatCmd(params []interface{}, out *ResultMessage) {
 			"Message": chatmessage,
 		},
 	}
-	data, err := json.Marshal(msgtoall)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	broadcast(data)
+	broadcast(msgtoall)
 	return
 }
 
-// converts the playerlist into an array of usernames, friendly for
-// displaying the list of added players.
-func sprintPlayerList() []string {
-	s := make([]string, len(playerlist))
-	i := 0
-	for key, _ := range playerlist {
-		s[i] = key
-		i++
-	}
-	return s
-}
-
-type Loc struct {
-	X int
-	Y int
+// mapMessage is the message that tells a client what the world map
+// looks like.
+func mapMessage() ResultMessage {
+	return ResultMessage{Kind: "map", Result: game.Snapshot().Map}
 }
 
-type Player struct {
-	PlayerId   int
-	CurrentPos Loc
-	TargetPos  Loc
-}
-
-func (p *Player) UpdatePosition() {
-	next := p.CurrentPos
-
-	if p.CurrentPos.X == p.TargetPos.X {
-		goto Skip1
-	}
-	if p.CurrentPos.X < p.TargetPos.X {
-		next.X = p.CurrentPos.X + 1
-	} else {
-		next.X = p.CurrentPos.X - 1
-	}
-Skip1:
-	if p.CurrentPos.Y == p.TargetPos.Y {
-		goto Skip2
-	}
-	if p.CurrentPos.Y < p.TargetPos.Y {
-		next.Y = p.CurrentPos.Y + 1
-	} else {
-		next.Y = p.CurrentPos.Y - 1
-	}
-Skip2:
-	// Check for collisions at new x-position
-	if NoCollisionAt(next.X, p.CurrentPos.Y) {
-		p.CurrentPos.X = next.X
-	}
-
-	// Check for collisions at new y-position
-	if NoCollisionAt(p.CurrentPos.X, next.X) {
-		p.CurrentPos.Y = next.Y
-	}
-}
-
-// TODO: add collision checking; currently there are no collisions.
-//
-// NoCollisionAt checks the tile at (x,y) to see if there is something
-// that might prevent movement to that tile.
-func NoCollisionAt(x, y int) bool {
-	return true
-}
-
-// The Game Ticker continuously updates the game, by checking if
-// players have moved, and updating their positions at fixed
-// intervals.
-func runGameTicker() {
-	// note: the ability to create additional tickers might result
-	// in goroutine leaks, because the docs say that the
-	// ticker.Stop() does not actually close the channel.
-	ticker := time.NewTicker(TICK_DURATION)
-	refresher := time.NewTicker(PLAYERLIST_REFRESH_DURATION)
-	for {
-		select {
-		case <-StartTickerChan:
-			ticker = time.NewTicker(TICK_DURATION)
-		case <-StopTickerChan:
-			ticker.Stop()
-		case <-ticker.C:
-			doGameTick()
-		case <-refresher.C:
-			doPlayerlistRefresh()
+// runBroadcaster sends the player list to every client after each
+// tick of the game.
+func runBroadcaster(snapshots <-chan gamestate.Snapshot) {
+	for s := range snapshots {
+		if len(s.Players) == 0 || clientlist.len() == 0 {
+			continue
 		}
+		broadcast(ResultMessage{Kind: "playerlist", Result: s.Players})
 	}
 }
-
-func doGameTick() {
-	if len(playerlist) == 0 || len(clientlist) == 0 {
-		return
-	}
-	send([]byte(`{"method": "update"}`))
-	out := send([]byte(`{"method": "list"}`))
-	broadcast(out)
-}
-
-// looks through the list of players, and compares them to the
-// activeplayer set.  If there are any players who AREN'T in the
-// activeplayer set, then they are considered "inactive" and will be
-// logged out.
-func doPlayerlistRefresh() {
-	for name := range playerlist {
-		_, ok := activeplayers[name]
-		if !ok {
-			delete(playerlist, name)
-		}
-	}
-	// clear the activeplayer set.
-	activeplayers = make(map[string]bool)
-}
-
-// call setPlayerToActive when a player does some action that allows
-// it to stay logged in.
-func setPlayerToActive(name string) {
-	activeplayers[name] = true
-}
@@ -0,0 +1,202 @@
+package gamestate
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownTick is returned by History.Ack for a tick that was never
+// sent.
+var ErrUnknownTick = errors.New("tick has not been sent.")
+
+// Update is the state of the players that is sent to a client after a
+// tick.  A full Update contains every player.  Otherwise, it is a
+// delta from the snapshot of the Base tick, which the client has
+// acknowledged: Players holds the players that were added or changed,
+// and Removed names the players that are gone.
+type Update struct {
+	Tick    uint64            `json:"tick"`
+	Base    uint64            `json:"base,omitempty"`
+	Full    bool              `json:"full,omitempty"`
+	Players map[string]Player `json:"players,omitempty"`
+	Removed []string          `json:"removed,omitempty"`
+}
+
+// Diff finds the changes to the players from one snapshot to another.
+func Diff(from, to Snapshot) Update {
+	u := Update{Tick: to.Tick, Base: from.Tick, Players: map[string]Player{}}
+	for name, p := range to.Players {
+		if old, ok := from.Players[name]; !ok || !samePlayer(old, p) {
+			u.Players[name] = p
+		}
+	}
+	for name := range from.Players {
+		if _, ok := to.Players[name]; !ok {
+			u.Removed = append(u.Removed, name)
+		}
+	}
+	sort.Strings(u.Removed)
+	return u
+}
+
+// Full is an Update with every player in the snapshot.
+func Full(s Snapshot) Update {
+	return Update{Tick: s.Tick, Full: true, Players: s.Players}
+}
+
+// Apply returns the snapshot that an Update describes.  For a delta,
+// base must be the snapshot of its Base tick.  Clients do the same
+// thing to keep track of the players.
+func (u Update) Apply(base Snapshot) Snapshot {
+	s := Snapshot{Tick: u.Tick, Players: map[string]Player{}, Map: base.Map}
+	if !u.Full {
+		for name, p := range base.Players {
+			s.Players[name] = p
+		}
+	}
+	for _, name := range u.Removed {
+		delete(s.Players, name)
+	}
+	for name, p := range u.Players {
+		s.Players[name] = p
+	}
+	return s
+}
+
+// samePlayer compares the parts of the players that are sent to the
+// clients.
+func samePlayer(a, b Player) bool {
+	return a.ID == b.ID &&
+		a.CurrentPosition == b.CurrentPosition &&
+		a.TargetPosition == b.TargetPosition &&
+		reflect.DeepEqual(a.Path, b.Path)
+}
+
+// HistorySize is the number of recent Updates that are remembered for
+// each client.  A client that hasn't acknowledged any of them gets a
+// full Update.
+var HistorySize = 32
+
+// Filter chooses the players that a client is sent.  A nil Filter
+// keeps all of them.
+type Filter func(name string, p Player) bool
+
+// History keeps the latest snapshot of a game, along with what each
+// client was sent and the last tick that it acknowledged, so that each
+// client can be sent only what changed since then.  It is safe for
+// concurrent use.
+//
+// Clients are identified by any number that is unique to them, like
+// the id of their websocket connection.  A client that reconnects gets
+// a new id, so it starts over with a full Update.
+type History struct {
+	mu      sync.Mutex
+	latest  *Snapshot
+	clients map[int]*clientHistory
+}
+
+// clientHistory is what a single client has been sent.  The views are
+// the players that the client knows about after each Update, oldest
+// first.
+type clientHistory struct {
+	views []Snapshot
+	acked uint64
+}
+
+// NewHistory creates an empty History.
+func NewHistory() *History {
+	return &History{clients: map[int]*clientHistory{}}
+}
+
+// Add records the latest snapshot.
+func (h *History) Add(s Snapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latest = &s
+}
+
+// Ack records that a client has received the Update for a tick.  Acks
+// for ticks older than the one already acknowledged are ignored, since
+// messages can arrive out of order.
+func (h *History) Ack(client int, tick uint64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.clients[client]
+	if !ok {
+		return ErrUnknownTick
+	}
+	if _, ok := c.find(tick); !ok {
+		return ErrUnknownTick
+	}
+	if tick > c.acked {
+		c.acked = tick
+	}
+	return nil
+}
+
+// Forget removes a client, for when it disconnects.
+func (h *History) Forget(client int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, client)
+}
+
+// Update returns what a client should be sent for the latest snapshot,
+// keeping only the players chosen by the filter.  It is a delta from
+// the last tick the client acknowledged, or a full Update if the client
+// hasn't acknowledged anything that is still remembered.  Players that
+// no longer pass the filter are Removed, just like players that left
+// the game.  The second result is false when there are no snapshots
+// yet.
+func (h *History) Update(client int, keep Filter) (Update, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.latest == nil {
+		return Update{}, false
+	}
+	view := filtered(*h.latest, keep)
+
+	c, ok := h.clients[client]
+	if !ok {
+		c = &clientHistory{}
+		h.clients[client] = c
+	}
+	c.views = append(c.views, view)
+	if n := len(c.views) - HistorySize; n > 0 {
+		c.views = append([]Snapshot(nil), c.views[n:]...)
+	}
+
+	base, ok := c.find(c.acked)
+	if !ok || c.acked == 0 {
+		return Full(view), true
+	}
+	return Diff(base, view), true
+}
+
+// find returns the view that was sent for a tick.
+func (c *clientHistory) find(tick uint64) (Snapshot, bool) {
+	for i := len(c.views) - 1; i >= 0; i-- {
+		if c.views[i].Tick == tick {
+			return c.views[i], true
+		}
+	}
+	return Snapshot{}, false
+}
+
+// filtered returns a copy of the snapshot with only the players that
+// pass the filter.
+func filtered(s Snapshot, keep Filter) Snapshot {
+	if keep == nil {
+		return s
+	}
+	players := make(map[string]Player, len(s.Players))
+	for name, p := range s.Players {
+		if keep(name, p) {
+			players[name] = p
+		}
+	}
+	s.Players = players
+	return s
+}
@@ -0,0 +1,134 @@
+package gamestate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func snap(tick uint64, players ...Player) Snapshot {
+	s := Snapshot{Tick: tick, Players: map[string]Player{}}
+	for _, p := range players {
+		s.Players[p.Name] = p
+	}
+	return s
+}
+
+func at(name string, x float64) Player {
+	return Player{ID: int(x), Name: name, CurrentPosition: Location3{X: x}}
+}
+
+func TestDiff(t *testing.T) {
+	from := snap(1, at("alice", 1), at("bob", 2), at("carol", 3))
+	to := snap(2, at("alice", 1), at("bob", 5), at("dave", 4))
+
+	u := Diff(from, to)
+	expected := Update{
+		Tick:    2,
+		Base:    1,
+		Players: map[string]Player{"bob": at("bob", 5), "dave": at("dave", 4)},
+		Removed: []string{"carol"},
+	}
+	if !reflect.DeepEqual(u, expected) {
+		t.Errorf("\n got: %+v\nwant: %+v", u, expected)
+	}
+	if got := u.Apply(from); !reflect.DeepEqual(got.Players, to.Players) {
+		t.Errorf("applying the delta gave %v, expected %v", got.Players, to.Players)
+	}
+
+	// a path is part of what the clients see.
+	moving := at("alice", 1)
+	moving.Path = []Location3{{X: 2}}
+	if u := Diff(from, snap(3, moving, at("bob", 2), at("carol", 3))); len(u.Players) != 1 {
+		t.Errorf("expected only alice to change, got %v", u.Players)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	defer func(n int) { HistorySize = n }(HistorySize)
+	HistorySize = 3
+
+	h := NewHistory()
+	if _, ok := h.Update(7, nil); ok {
+		t.Error("expected no update before the first snapshot")
+	}
+
+	// a new client gets everything.
+	h.Add(snap(1, at("alice", 1)))
+	if u, _ := h.Update(7, nil); !u.Full || u.Tick != 1 || len(u.Players) != 1 {
+		t.Errorf("expected a full update, got %+v", u)
+	}
+	h.Add(snap(2, at("alice", 2)))
+	h.Update(7, nil)
+
+	// ticks that weren't sent to the client can't be acknowledged.
+	if err := h.Ack(7, 5); err != ErrUnknownTick {
+		t.Errorf("expected ErrUnknownTick, got %v", err)
+	}
+	if err := h.Ack(8, 1); err != ErrUnknownTick {
+		t.Errorf("expected ErrUnknownTick, got %v", err)
+	}
+
+	// once it acknowledges a tick, it gets deltas from there.
+	if err := h.Ack(7, 1); err != nil {
+		t.Fatal(err)
+	}
+	h.Add(snap(3, at("alice", 2), at("bob", 3)))
+	u, _ := h.Update(7, nil)
+	if u.Full || u.Base != 1 || u.Tick != 3 || len(u.Players) != 2 {
+		t.Errorf("expected a delta from tick 1, got %+v", u)
+	}
+	h.Ack(7, 3)
+	h.Ack(7, 2) // arrives late, and is ignored.
+	h.Add(snap(4, at("alice", 2), at("bob", 3)))
+	if u, _ := h.Update(7, nil); u.Full || u.Base != 3 || len(u.Players) != 0 || u.Removed != nil {
+		t.Errorf("expected an empty delta from tick 3, got %+v", u)
+	}
+
+	// a client that falls behind the history starts over.
+	for tick := uint64(5); tick <= 7; tick++ {
+		h.Add(snap(tick))
+		h.Update(7, nil)
+	}
+	h.Add(snap(8))
+	if u, _ := h.Update(7, nil); !u.Full || u.Tick != 8 {
+		t.Errorf("expected a full update, got %+v", u)
+	}
+
+	// and so does one that is forgotten.
+	h.Ack(7, 8)
+	h.Forget(7)
+	if u, _ := h.Update(7, nil); !u.Full {
+		t.Errorf("expected a full update, got %+v", u)
+	}
+}
+
+func TestHistoryFilter(t *testing.T) {
+	h := NewHistory()
+	near := func(name string, p Player) bool { return p.CurrentPosition.X < 10 }
+
+	h.Add(snap(1, at("alice", 1), at("bob", 20)))
+	u, _ := h.Update(1, near)
+	if !u.Full || len(u.Players) != 1 {
+		t.Errorf("expected only alice, got %+v", u)
+	}
+	h.Ack(1, 1)
+
+	// bob comes close, and alice walks away.
+	h.Add(snap(2, at("alice", 11), at("bob", 5)))
+	u, _ = h.Update(1, near)
+	expected := Update{Tick: 2, Base: 1, Players: map[string]Player{"bob": at("bob", 5)},
+		Removed: []string{"alice"}}
+	if !reflect.DeepEqual(u, expected) {
+		t.Errorf("\n got: %+v\nwant: %+v", u, expected)
+	}
+
+	// the filter can change without confusing the deltas, since they
+	// are based on what was actually sent.
+	h.Ack(1, 2)
+	h.Add(snap(3, at("alice", 11), at("bob", 5)))
+	u, _ = h.Update(1, nil)
+	expected = Update{Tick: 3, Base: 2, Players: map[string]Player{"alice": at("alice", 11)}}
+	if !reflect.DeepEqual(u, expected) {
+		t.Errorf("\n got: %+v\nwant: %+v", u, expected)
+	}
+}
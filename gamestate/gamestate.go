@@ -1,8 +1,13 @@
 package gamestate
 
 import (
+	"errors"
 	"log"
+	"sort"
+	"sync"
 	"time"
+
+	"github.com/tilegame/gameserver/tilemap"
 )
 
 type GameMessageKind int
@@ -11,27 +16,133 @@ const (
 	Example GameMessageKind = iota
 	AddPlayer
 	RemovePlayer
+	ChangeMap
+	MovePlayer
+	GetSnapshot
+	Subscribe
+	Step
+	PlayersNear
+	NearestPlayers
+	PlayersAt
+	OnRemove
+)
+
+// The settings of the games.  They are read by the Game Hub while it
+// runs, so they should be set before NewGame is called.
+var (
+	// TickDuration is how often the game is updated when created by
+	// NewGame.
+	TickDuration = time.Millisecond * 500
+
+	// PlayerTimeout is how long a player can go without doing
+	// anything before they are removed from the game.
+	PlayerTimeout = 3 * time.Minute
+
+	// DiagonalMovement allows players to move diagonally, as long as
+	// they don't cut the corner of a blocked tile.
+	DiagonalMovement = true
+
+	// SpawnPoint is where new players are placed, if that tile is
+	// walkable.  Otherwise, they are placed on the first walkable
+	// tile of the map.
+	SpawnPoint = tilemap.Point{X: 5, Y: 5}
+
+	// IndexCellSize is the size of the cells, in tiles, of the Grid
+	// that the game uses to find players by their location.
+	IndexCellSize = 8.0
 )
 
+var (
+	ErrPlayerExists = errors.New("player already exists.")
+	ErrNoPlayer     = errors.New("player does not exist.")
+	ErrUnreachable  = errors.New("target is unreachable.")
+	ErrNoSpawn      = errors.New("there is nowhere to place a new player.")
+	ErrBadMessage   = errors.New("message data has the wrong type.")
+	ErrStopped      = errors.New("the game has been stopped.")
+)
+
+// Game is the authoritative state of the game world.  All of its state
+// is owned by a single goroutine, the Game Hub, which processes the
+// GameMessages sent to the MessageChannel one at a time, and updates
+// the world at a fixed rate.  This means the methods of Game are safe
+// for concurrent use.
 type Game struct {
 	StartTime      time.Time
 	MessageChannel chan GameMessage
-	playerMap      map[int]Player
+	world          *tilemap.Map
+	playerMap      map[string]*Player
+	index          *Grid
+	nextPlayerId   int
+	tickCount      uint64
+	tickDuration   time.Duration
+	subscribers    []chan Snapshot
+	removeHooks    []func(Player)
+	done           chan struct{}
+	stopOnce       sync.Once
 }
 
 // GameMessage is the structure of the messages interpretted by the Game Hub,
 // they are like commands to the tell the game instance what to do.  Examples
 // are adding, deleting, or moving players.
+//
+// If Reply is not nil, the outcome of the message is sent to it once
+// the message has been handled.  It should be buffered, so that the
+// Game Hub never waits on it.
 type GameMessage struct {
-	Kind GameMessageKind
-	Data interface{}
+	Kind  GameMessageKind
+	Data  interface{}
+	Reply chan GameReply
+}
+
+// GameReply is the outcome of a GameMessage.
+type GameReply struct {
+	Value interface{}
+	Err   error
+}
+
+// MoveOrder is the Data of a MovePlayer message.
+type MoveOrder struct {
+	Name   string
+	Target tilemap.Point
+}
+
+// AreaQuery is the Data of the PlayersNear and NearestPlayers
+// messages.  PlayersNear uses the Radius, and NearestPlayers uses the
+// Count.
+type AreaQuery struct {
+	Center Location3
+	Radius float64
+	Count  int
 }
 
+// Snapshot is a copy of the game state at a single moment in time.
+// It is safe to read, and to send to the clients, while the game keeps
+// running.  The Map is shared with the game, and must not be modified.
+type Snapshot struct {
+	Tick    uint64
+	Time    time.Time
+	Players map[string]Player
+	Map     *tilemap.Map
+}
+
+// NewGame creates a game that is updated every TickDuration, and
+// starts its Game Hub.
 func NewGame() *Game {
+	return NewGameWithTick(TickDuration)
+}
+
+// NewGameWithTick creates a game that is updated at the given
+// interval, and starts its Game Hub.
+func NewGameWithTick(tick time.Duration) *Game {
 	g := &Game{
 		StartTime:      time.Now(),
-		playerMap:      make(map[int]Player),
+		playerMap:      make(map[string]*Player),
+		index:          NewGrid(IndexCellSize),
 		MessageChannel: make(chan GameMessage),
+		world:          tilemap.NewRoom("default", 32, 32),
+		nextPlayerId:   136,
+		tickDuration:   tick,
+		done:           make(chan struct{}),
 	}
 	go g.runGameMessageHub()
 	return g
@@ -41,55 +152,400 @@ func NewGame() *Game {
 // a nw game is created.  This should happen internally, ensuring that
 // it only happens once for each game instance.
 func (g *Game) runGameMessageHub() {
-	select {
-	case m := <-g.MessageChannel:
-		g.handleGameMessage(m)
+	ticker := time.NewTicker(g.tickDuration)
+	refresher := time.NewTicker(PlayerTimeout)
+	defer ticker.Stop()
+	defer refresher.Stop()
+	for {
+		select {
+		case m := <-g.MessageChannel:
+			reply := g.handleGameMessage(m)
+			if m.Reply != nil {
+				m.Reply <- reply
+			}
+		case <-ticker.C:
+			g.tick()
+		case <-refresher.C:
+			g.removeInactivePlayers()
+		case <-g.done:
+			for _, s := range g.subscribers {
+				close(s)
+			}
+			return
+		}
 	}
 }
 
-func (g *Game) handleGameMessage(m GameMessage) {
+func (g *Game) handleGameMessage(m GameMessage) GameReply {
 	switch m.Kind {
 	case Example:
 		log.Println("Example message received!")
 
 	case AddPlayer:
-		id, ok := m.Data.(int)
-		if ok {
-			g.addPlayer(id)
-		} else {
-			log.Println("AddPlayerMessage: data needs to be integer")
+		name, ok := m.Data.(string)
+		if !ok {
+			log.Println("AddPlayerMessage: data needs to be string")
+			return GameReply{Err: ErrBadMessage}
+		}
+		p, err := g.addPlayer(name)
+		if err != nil {
+			return GameReply{Err: err}
 		}
+		return GameReply{Value: p.copy()}
 
 	case RemovePlayer:
-		id, ok := m.Data.(int)
-		if ok {
-			delete(g.playerMap, id)
-		} else {
-			log.Println("AddPlayerMessage: data needs to be integer")
+		name, ok := m.Data.(string)
+		if !ok {
+			log.Println("RemovePlayerMessage: data needs to be string")
+			return GameReply{Err: ErrBadMessage}
 		}
+		if _, ok := g.playerMap[name]; !ok {
+			return GameReply{Err: ErrNoPlayer}
+		}
+		g.removePlayer(name)
+
+	case ChangeMap:
+		world, ok := m.Data.(*tilemap.Map)
+		if !ok {
+			log.Println("ChangeMapMessage: data needs to be *tilemap.Map")
+			return GameReply{Err: ErrBadMessage}
+		}
+		g.changeMap(world)
+
+	case MovePlayer:
+		order, ok := m.Data.(MoveOrder)
+		if !ok {
+			log.Println("MovePlayerMessage: data needs to be MoveOrder")
+			return GameReply{Err: ErrBadMessage}
+		}
+		return GameReply{Err: g.movePlayer(order)}
+
+	case GetSnapshot:
+		return GameReply{Value: g.snapshot()}
+
+	case Subscribe:
+		ch := make(chan Snapshot, 1)
+		g.subscribers = append(g.subscribers, ch)
+		return GameReply{Value: ch}
+
+	case Step:
+		g.tick()
+
+	case PlayersNear, NearestPlayers:
+		q, ok := m.Data.(AreaQuery)
+		if !ok {
+			log.Println("AreaQueryMessage: data needs to be AreaQuery")
+			return GameReply{Err: ErrBadMessage}
+		}
+		if m.Kind == PlayersNear {
+			return GameReply{Value: g.index.Within(q.Center, q.Radius)}
+		}
+		return GameReply{Value: g.index.Nearest(q.Center, q.Count)}
+
+	case PlayersAt:
+		tile, ok := m.Data.(tilemap.Point)
+		if !ok {
+			log.Println("PlayersAtMessage: data needs to be tilemap.Point")
+			return GameReply{Err: ErrBadMessage}
+		}
+		return GameReply{Value: g.index.At(tile)}
+
+	case OnRemove:
+		f, ok := m.Data.(func(Player))
+		if !ok {
+			log.Println("OnRemoveMessage: data needs to be func(Player)")
+			return GameReply{Err: ErrBadMessage}
+		}
+		g.removeHooks = append(g.removeHooks, f)
 	}
+	return GameReply{}
 }
 
-// addPlayer() is not safe for concurrent execution.  Returns false if
+// addPlayer() is not safe for concurrent execution.  Returns an error if
 // there is already a player by the given name.
-func (g *Game) addPlayer(id int) bool {
-	_, ok := g.playerMap[id]
+func (g *Game) addPlayer(name string) (*Player, error) {
+	if _, ok := g.playerMap[name]; ok {
+		return nil, ErrPlayerExists
+	}
+	spawn, ok := g.spawnPoint()
+	if !ok {
+		return nil, ErrNoSpawn
+	}
+	g.nextPlayerId++
+	p := &Player{
+		ID:              g.nextPlayerId,
+		Name:            name,
+		CurrentPosition: LocationOf(spawn),
+		TargetPosition:  LocationOf(spawn),
+		lastActive:      time.Now(),
+	}
+	g.playerMap[name] = p
+	g.index.Insert(name, p.CurrentPosition)
+	return p, nil
+}
+
+// removePlayer takes a player out of the game and the index, and tells
+// the OnRemove hooks that it is gone.
+func (g *Game) removePlayer(name string) {
+	p, ok := g.playerMap[name]
 	if !ok {
-		return false
+		return
+	}
+	delete(g.playerMap, name)
+	g.index.Remove(name)
+	for _, f := range g.removeHooks {
+		f(p.copy())
+	}
+}
+
+// spawnPoint finds the tile where a new player should be placed.
+// Unless players are Passable, the tile also has to be empty.
+func (g *Game) spawnPoint() (tilemap.Point, bool) {
+	if g.free(SpawnPoint.X, SpawnPoint.Y) {
+		return SpawnPoint, true
+	}
+	for y := 0; y < g.world.Height; y++ {
+		for x := 0; x < g.world.Width; x++ {
+			if g.free(x, y) {
+				return tilemap.Point{X: x, Y: y}, true
+			}
+		}
+	}
+	return tilemap.Point{}, false
+}
+
+func (g *Game) movePlayer(order MoveOrder) error {
+	p, ok := g.playerMap[order.Name]
+	if !ok {
+		return ErrNoPlayer
+	}
+	path, err := g.findPath(p.CurrentPosition.Tile(), order.Target)
+	if err != nil {
+		return ErrUnreachable
+	}
+	p.TargetPosition = LocationOf(order.Target)
+	p.Path = path
+	p.lastActive = time.Now()
+	return nil
+}
+
+// changeMap replaces the world.  Paths that were found on the old map
+// are discarded, and players who would be stuck inside of a wall are
+// moved back to the spawn point.
+func (g *Game) changeMap(world *tilemap.Map) {
+	g.world = world
+	for _, p := range g.sortedPlayers() {
+		p.Path = nil
+		here := p.CurrentPosition.Tile()
+		if !g.world.Walkable(here.X, here.Y) {
+			if spawn, ok := g.spawnPoint(); ok {
+				p.CurrentPosition = LocationOf(spawn)
+				g.index.Insert(p.Name, p.CurrentPosition)
+			}
+		}
+		p.TargetPosition = p.CurrentPosition
+	}
+}
+
+// findPath returns the steps needed to walk between two tiles of the
+// world map, not including the starting tile.
+func (g *Game) findPath(from, to tilemap.Point) ([]Location3, error) {
+	points, err := g.world.FindPath(from, to,
+		tilemap.PathOptions{Diagonal: DiagonalMovement})
+	if err != nil {
+		return nil, err
+	}
+	path := make([]Location3, len(points))
+	for i, pt := range points {
+		path[i] = LocationOf(pt)
+	}
+	return path, nil
+}
+
+// tick advances the game by a single step, moving every player along
+// their path.  Players are updated in order of their ids, so the
+// outcome of a tick doesn't depend on the order of the player map,
+// even when the TileOccupancy keeps them from sharing tiles.
+// Afterwords, a snapshot is sent to all of the subscribers.
+func (g *Game) tick() {
+	g.tickCount++
+	g.moveAll()
+	g.publish()
+}
+
+func (g *Game) sortedPlayers() []*Player {
+	players := make([]*Player, 0, len(g.playerMap))
+	for _, p := range g.playerMap {
+		players = append(players, p)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].ID < players[j].ID
+	})
+	return players
+}
+
+// publish sends a snapshot to every subscriber.  Subscribers that are
+// slow to read only ever see the latest snapshot, since the stale one
+// is thrown away.
+func (g *Game) publish() {
+	if len(g.subscribers) == 0 {
+		return
+	}
+	s := g.snapshot()
+	for _, ch := range g.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- s
+	}
+}
+
+func (g *Game) snapshot() Snapshot {
+	s := Snapshot{
+		Tick:    g.tickCount,
+		Time:    time.Now(),
+		Players: make(map[string]Player, len(g.playerMap)),
+		Map:     g.world,
+	}
+	for name, p := range g.playerMap {
+		s.Players[name] = p.copy()
+	}
+	return s
+}
+
+// looks through the list of players, and removes any of them who
+// haven't done anything for longer than the PlayerTimeout.
+func (g *Game) removeInactivePlayers() {
+	for name, p := range g.playerMap {
+		if time.Since(p.lastActive) > PlayerTimeout {
+			g.removePlayer(name)
+		}
 	}
-	g.playerMap[id] = Player{}
-	return true
 }
 
 func (g *Game) Uptime() time.Duration {
 	return time.Now().Sub(g.StartTime)
 }
 
-type Player struct {
-	CurrentPosition Location3
-	TargetPosition  Location3
+// ------------------------------------------------------------------
+// Methods that send messages to the Game Hub.  These are safe for
+// concurrent use.
+// ------------------------------------------------------------------
+
+// send delivers a message to the Game Hub and waits for its reply.
+func (g *Game) send(kind GameMessageKind, data interface{}) GameReply {
+	reply := make(chan GameReply, 1)
+	m := GameMessage{Kind: kind, Data: data, Reply: reply}
+	select {
+	case g.MessageChannel <- m:
+	case <-g.done:
+		return GameReply{Err: ErrStopped}
+	}
+	return <-reply
+}
+
+// AddPlayer places a new player at the spawn point.
+func (g *Game) AddPlayer(name string) (Player, error) {
+	r := g.send(AddPlayer, name)
+	if r.Err != nil {
+		return Player{}, r.Err
+	}
+	return r.Value.(Player), nil
+}
+
+// RemovePlayer takes a player out of the game.
+func (g *Game) RemovePlayer(name string) error {
+	return g.send(RemovePlayer, name).Err
+}
+
+// MovePlayer finds a path from the player's current position to the
+// tile at (x,y).  The player then takes a step along that path every
+// tick.  ErrUnreachable is returned if there is no path.
+func (g *Game) MovePlayer(name string, x, y int) error {
+	order := MoveOrder{Name: name, Target: tilemap.Point{X: x, Y: y}}
+	return g.send(MovePlayer, order).Err
+}
+
+// SetMap replaces the world map.
+func (g *Game) SetMap(m *tilemap.Map) error {
+	return g.send(ChangeMap, m).Err
+}
+
+// Snapshot returns a consistent copy of the game state.
+func (g *Game) Snapshot() Snapshot {
+	r := g.send(GetSnapshot, nil)
+	if r.Err != nil {
+		return Snapshot{}
+	}
+	return r.Value.(Snapshot)
+}
+
+// Player looks up a player by name.
+func (g *Game) Player(name string) (Player, bool) {
+	p, ok := g.Snapshot().Players[name]
+	return p, ok
+}
+
+// PlayersNear returns the names of the players within radius tiles of
+// a location, sorted.
+func (g *Game) PlayersNear(l Location3, radius float64) []string {
+	r := g.send(PlayersNear, AreaQuery{Center: l, Radius: radius})
+	if r.Err != nil {
+		return nil
+	}
+	return r.Value.([]string)
+}
+
+// NearestPlayers returns the names of the n players closest to a
+// location, nearest first.
+func (g *Game) NearestPlayers(l Location3, n int) []string {
+	r := g.send(NearestPlayers, AreaQuery{Center: l, Count: n})
+	if r.Err != nil {
+		return nil
+	}
+	return r.Value.([]string)
+}
+
+// PlayersAt returns the names of the players on the tile at (x,y),
+// sorted.  A tile is free when there are none.
+func (g *Game) PlayersAt(x, y int) []string {
+	r := g.send(PlayersAt, tilemap.Point{X: x, Y: y})
+	if r.Err != nil {
+		return nil
+	}
+	return r.Value.([]string)
+}
+
+// Subscribe returns a channel that receives a Snapshot after every
+// tick.  The channel is closed when the game is stopped.
+func (g *Game) Subscribe() <-chan Snapshot {
+	r := g.send(Subscribe, nil)
+	if r.Err != nil {
+		ch := make(chan Snapshot)
+		close(ch)
+		return ch
+	}
+	return r.Value.(chan Snapshot)
+}
+
+// OnRemove calls f with every player that leaves the game, whether
+// they were removed with RemovePlayer or for being inactive longer than
+// the PlayerTimeout.  The player is gone before another one by the same
+// name can be added.  f is called by the Game Hub itself, so it must be
+// quick, and must not call the methods of the Game.
+func (g *Game) OnRemove(f func(Player)) error {
+	return g.send(OnRemove, f).Err
+}
+
+// Step advances the game by one tick immediately, without waiting for
+// the ticker.
+func (g *Game) Step() {
+	g.send(Step, nil)
 }
 
-type Location3 struct {
-	x, y, z float64
+// Stop shuts down the Game Hub.  Calling any other method afterwords
+// returns ErrStopped.
+func (g *Game) Stop() {
+	g.stopOnce.Do(func() { close(g.done) })
 }
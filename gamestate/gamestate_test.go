@@ -0,0 +1,194 @@
+package gamestate
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tilegame/gameserver/tilemap"
+)
+
+// newTestGame creates a game with a ticker that is too slow to fire
+// during a test, so the game only advances when Step is called.
+func newTestGame(t *testing.T) *Game {
+	g := NewGameWithTick(time.Hour)
+	t.Cleanup(g.Stop)
+	return g
+}
+
+func TestAddAndRemovePlayer(t *testing.T) {
+	g := newTestGame(t)
+
+	p, err := g.AddPlayer("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CurrentPosition.Tile() != SpawnPoint {
+		t.Errorf("player spawned at %v instead of %v",
+			p.CurrentPosition.Tile(), SpawnPoint)
+	}
+	if _, err := g.AddPlayer("alice"); err != ErrPlayerExists {
+		t.Errorf("expected ErrPlayerExists, got %v", err)
+	}
+	if _, ok := g.Player("alice"); !ok {
+		t.Error("alice was added, but isn't in the game.")
+	}
+
+	if err := g.RemovePlayer("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.RemovePlayer("alice"); err != ErrNoPlayer {
+		t.Errorf("expected ErrNoPlayer, got %v", err)
+	}
+	if n := len(g.Snapshot().Players); n != 0 {
+		t.Errorf("expected an empty game, found %d players", n)
+	}
+}
+
+func TestMovePlayerAlongPath(t *testing.T) {
+	g := newTestGame(t)
+	world := tilemap.NewRoom("test", 10, 10)
+	world.Set(tilemap.Objects, 6, 5, tilemap.Wall)
+	if err := g.SetMap(world); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.AddPlayer("bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.MovePlayer("bob", 7, 5); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		g.Step()
+		p, _ := g.Player("bob")
+		here := p.CurrentPosition.Tile()
+		if !world.Walkable(here.X, here.Y) {
+			t.Fatalf("player walked into a wall at %v", here)
+		}
+	}
+	p, _ := g.Player("bob")
+	if p.CurrentPosition.Tile() != (tilemap.Point{X: 7, Y: 5}) {
+		t.Errorf("player should have arrived, but is at %v",
+			p.CurrentPosition.Tile())
+	}
+	if len(p.Path) != 0 {
+		t.Errorf("player arrived, but still has a path: %v", p.Path)
+	}
+}
+
+func TestMoveErrors(t *testing.T) {
+	g := newTestGame(t)
+	if err := g.MovePlayer("nobody", 1, 1); err != ErrNoPlayer {
+		t.Errorf("expected ErrNoPlayer, got %v", err)
+	}
+	g.AddPlayer("carol")
+	if err := g.MovePlayer("carol", 0, 0); err != ErrUnreachable {
+		t.Errorf("expected ErrUnreachable for a wall, got %v", err)
+	}
+}
+
+func TestPlayerIndex(t *testing.T) {
+	g := newTestGame(t)
+	g.AddPlayer("alice")
+	g.AddPlayer("bob")
+	if names := g.PlayersAt(SpawnPoint.X, SpawnPoint.Y); !reflect.DeepEqual(names, []string{"alice", "bob"}) {
+		t.Errorf("expected both players on the spawn point, got %v", names)
+	}
+
+	// the index follows the players as they walk.
+	g.MovePlayer("bob", SpawnPoint.X+3, SpawnPoint.Y)
+	for i := 0; i < 3; i++ {
+		g.Step()
+	}
+	spawn := LocationOf(SpawnPoint)
+	if names := g.PlayersNear(spawn, 1); !reflect.DeepEqual(names, []string{"alice"}) {
+		t.Errorf("expected only alice near the spawn point, got %v", names)
+	}
+	if names := g.PlayersAt(SpawnPoint.X+3, SpawnPoint.Y); !reflect.DeepEqual(names, []string{"bob"}) {
+		t.Errorf("expected bob to have arrived, got %v", names)
+	}
+	if names := g.NearestPlayers(LocationOf(tilemap.Point{X: 9, Y: 5}), 2); !reflect.DeepEqual(names, []string{"bob", "alice"}) {
+		t.Errorf("expected bob to be nearest, got %v", names)
+	}
+
+	g.RemovePlayer("alice")
+	if names := g.PlayersNear(spawn, 10); !reflect.DeepEqual(names, []string{"bob"}) {
+		t.Errorf("expected alice to be gone, got %v", names)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	g := newTestGame(t)
+	snapshots := g.Subscribe()
+	g.AddPlayer("dave")
+	g.Step()
+
+	s := <-snapshots
+	if s.Tick != 1 {
+		t.Errorf("expected tick 1, got %d", s.Tick)
+	}
+	if _, ok := s.Players["dave"]; !ok {
+		t.Error("the snapshot is missing a player.")
+	}
+
+	g.Stop()
+	if _, ok := <-snapshots; ok {
+		t.Error("the channel should be closed when the game stops.")
+	}
+	if err := g.RemovePlayer("dave"); err != ErrStopped {
+		t.Errorf("expected ErrStopped, got %v", err)
+	}
+}
+
+func TestConcurrentMessages(t *testing.T) {
+	g := newTestGame(t)
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			g.AddPlayer(name)
+			g.MovePlayer(name, 8, 8)
+			g.Step()
+			g.Snapshot()
+		}(name)
+	}
+	wg.Wait()
+	if n := len(g.Snapshot().Players); n != len(names) {
+		t.Errorf("expected %d players, got %d", len(names), n)
+	}
+}
+
+func TestOnRemove(t *testing.T) {
+	defer func(d time.Duration) { PlayerTimeout = d }(PlayerTimeout)
+	PlayerTimeout = 20 * time.Millisecond
+	g := newTestGame(t)
+
+	removed := make(chan string, 4)
+	if err := g.OnRemove(func(p Player) { removed <- p.Name }); err != nil {
+		t.Fatal(err)
+	}
+	g.AddPlayer("erin")
+	g.RemovePlayer("erin")
+	g.RemovePlayer("erin")
+	if name := <-removed; name != "erin" {
+		t.Errorf("expected erin to be removed, got %q", name)
+	}
+
+	// players who time out are removed too.
+	g.AddPlayer("frank")
+	select {
+	case name := <-removed:
+		if name != "frank" {
+			t.Errorf("expected frank to time out, got %q", name)
+		}
+	case <-time.After(time.Second):
+		t.Error("frank never timed out.")
+	}
+	if len(removed) != 0 {
+		t.Errorf("expected each player to be removed once, got %d more", len(removed))
+	}
+}
@@ -0,0 +1,223 @@
+package gamestate
+
+import (
+	"math"
+	"sort"
+
+	"github.com/tilegame/gameserver/tilemap"
+)
+
+// Grid is a spatial hash, which buckets names by their location on the
+// floor, so that finding the ones near a point only looks at a few
+// cells instead of everyone.  The height of a location is ignored.
+//
+// The names can be moved around after they are inserted, so a Grid can
+// be kept up to date as things move.  It is not safe for concurrent
+// use.
+type Grid struct {
+	cellSize float64
+	cells    map[cell][]string
+	at       map[string]Location3
+}
+
+type cell struct {
+	X, Y int
+}
+
+// NewGrid makes an empty Grid with square cells of the given size,
+// measured in tiles.  Cells about as big as the radius of the usual
+// query work best.
+func NewGrid(cellSize float64) *Grid {
+	if cellSize <= 0 {
+		cellSize = 1
+	}
+	return &Grid{
+		cellSize: cellSize,
+		cells:    map[cell][]string{},
+		at:       map[string]Location3{},
+	}
+}
+
+// GridOf makes a Grid with the current positions of the players.
+func GridOf(players map[string]Player, cellSize float64) *Grid {
+	g := NewGrid(cellSize)
+	for name, p := range players {
+		g.Insert(name, p.CurrentPosition)
+	}
+	return g
+}
+
+// Len is the number of names in the grid.
+func (g *Grid) Len() int {
+	return len(g.at)
+}
+
+// Insert puts a name in the grid at a location.  A name that is
+// already in the grid is moved there instead.
+func (g *Grid) Insert(name string, l Location3) {
+	if old, ok := g.at[name]; ok {
+		if g.cellOf(old) == g.cellOf(l) {
+			g.at[name] = l
+			return
+		}
+		g.Remove(name)
+	}
+	c := g.cellOf(l)
+	g.cells[c] = append(g.cells[c], name)
+	g.at[name] = l
+}
+
+// Remove takes a name out of the grid.
+func (g *Grid) Remove(name string) {
+	l, ok := g.at[name]
+	if !ok {
+		return
+	}
+	delete(g.at, name)
+	c := g.cellOf(l)
+	names := g.cells[c]
+	for i, n := range names {
+		if n == name {
+			names = append(names[:i], names[i+1:]...)
+			break
+		}
+	}
+	if len(names) == 0 {
+		delete(g.cells, c)
+	} else {
+		g.cells[c] = names
+	}
+}
+
+// Location returns where a name is in the grid.
+func (g *Grid) Location(name string) (Location3, bool) {
+	l, ok := g.at[name]
+	return l, ok
+}
+
+// Within returns the names that are at most radius tiles away from l,
+// sorted.
+func (g *Grid) Within(l Location3, radius float64) []string {
+	var names []string
+	g.eachNear(l, radius, func(name string, at Location3) {
+		if distance(at, l) <= radius {
+			names = append(names, name)
+		}
+	})
+	sort.Strings(names)
+	return names
+}
+
+// At returns the names on a tile, sorted.
+func (g *Grid) At(tile tilemap.Point) []string {
+	var names []string
+	g.eachNear(LocationOf(tile), 1, func(name string, at Location3) {
+		if at.Tile() == tile {
+			names = append(names, name)
+		}
+	})
+	sort.Strings(names)
+	return names
+}
+
+// Nearest returns up to n names that are closest to l, nearest first.
+// Names that are just as far away are sorted.
+func (g *Grid) Nearest(l Location3, n int) []string {
+	if n <= 0 || len(g.at) == 0 {
+		return nil
+	}
+	type found struct {
+		name string
+		d    float64
+	}
+	var candidates []found
+	byDistance := func() {
+		sort.Slice(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			if a.d != b.d {
+				return a.d < b.d
+			}
+			return a.name < b.name
+		})
+	}
+
+	// the cells are searched in rings around the cell of l.  Anything
+	// outside of ring r is more than r cells away, so once the n-th
+	// candidate is closer than that, the rest can't beat it.
+	center := g.cellOf(l)
+	for r := 0; len(candidates) < len(g.at); r++ {
+		g.eachInRing(center, r, func(name string) {
+			candidates = append(candidates, found{name, distance(g.at[name], l)})
+		})
+		if len(candidates) >= n {
+			byDistance()
+			if candidates[n-1].d < float64(r)*g.cellSize {
+				break
+			}
+		}
+	}
+	byDistance()
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c.name
+	}
+	return names
+}
+
+// eachNear calls f with every name in the cells that overlap the square
+// around l.  When that is more cells than the grid has, all of the
+// names are visited instead.
+func (g *Grid) eachNear(l Location3, radius float64, f func(string, Location3)) {
+	lo := g.cellOf(Location3{X: l.X - radius, Y: l.Y - radius})
+	hi := g.cellOf(Location3{X: l.X + radius, Y: l.Y + radius})
+	if (hi.X-lo.X+1)*(hi.Y-lo.Y+1) > len(g.cells) {
+		for name, at := range g.at {
+			f(name, at)
+		}
+		return
+	}
+	for x := lo.X; x <= hi.X; x++ {
+		for y := lo.Y; y <= hi.Y; y++ {
+			for _, name := range g.cells[cell{x, y}] {
+				f(name, g.at[name])
+			}
+		}
+	}
+}
+
+// eachInRing calls f with every name in the cells that are exactly r
+// cells away from c, counting diagonals as one.
+func (g *Grid) eachInRing(c cell, r int, f func(string)) {
+	visit := func(x, y int) {
+		for _, name := range g.cells[cell{x, y}] {
+			f(name)
+		}
+	}
+	if r == 0 {
+		visit(c.X, c.Y)
+		return
+	}
+	for x := c.X - r; x <= c.X+r; x++ {
+		visit(x, c.Y-r)
+		visit(x, c.Y+r)
+	}
+	for y := c.Y - r + 1; y <= c.Y+r-1; y++ {
+		visit(c.X-r, y)
+		visit(c.X+r, y)
+	}
+}
+
+func (g *Grid) cellOf(l Location3) cell {
+	return cell{
+		X: int(math.Floor(l.X / g.cellSize)),
+		Y: int(math.Floor(l.Y / g.cellSize)),
+	}
+}
+
+// distance is how far apart two locations are on the floor.
+func distance(a, b Location3) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
@@ -0,0 +1,105 @@
+package gamestate
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tilegame/gameserver/tilemap"
+)
+
+func TestGridWithin(t *testing.T) {
+	g := NewGrid(4)
+	g.Insert("alice", Location3{X: 0, Y: 0})
+	g.Insert("bob", Location3{X: 3, Y: 4})
+	g.Insert("carol", Location3{X: -3, Y: -4.5})
+	g.Insert("dave", Location3{X: 40, Y: 2})
+	g.Insert("erin", Location3{X: 5, Y: 0, Z: 10})
+
+	cases := []struct {
+		at     Location3
+		radius float64
+		names  []string
+	}{
+		{Location3{}, 5, []string{"alice", "bob", "erin"}},
+		{Location3{}, 6, []string{"alice", "bob", "carol", "erin"}},
+		{Location3{}, 0, []string{"alice"}},
+		{Location3{X: 38, Y: 2}, 2, []string{"dave"}},
+		{Location3{X: 100, Y: 100}, 10, nil},
+	}
+	for _, c := range cases {
+		names := g.Within(c.at, c.radius)
+		if !reflect.DeepEqual(names, c.names) {
+			t.Errorf("Within(%v, %v) = %v, expected %v", c.at, c.radius, names, c.names)
+		}
+	}
+}
+
+func TestGridOf(t *testing.T) {
+	g := GridOf(map[string]Player{
+		"alice": at("alice", 1),
+		"bob":   at("bob", 30),
+	}, 10)
+	if names := g.Within(Location3{}, 5); !reflect.DeepEqual(names, []string{"alice"}) {
+		t.Errorf("expected only alice nearby, got %v", names)
+	}
+}
+
+func TestGridMoveAndRemove(t *testing.T) {
+	g := NewGrid(2)
+	g.Insert("alice", Location3{X: 0, Y: 0})
+	g.Insert("bob", Location3{X: 1, Y: 0})
+
+	g.Insert("alice", Location3{X: 9, Y: 9})
+	if names := g.Within(Location3{}, 3); !reflect.DeepEqual(names, []string{"bob"}) {
+		t.Errorf("expected alice to have moved away, got %v", names)
+	}
+	if l, _ := g.Location("alice"); l != (Location3{X: 9, Y: 9}) {
+		t.Errorf("alice is at %v", l)
+	}
+	g.Remove("bob")
+	g.Remove("nobody")
+	if g.Len() != 1 || g.Within(Location3{}, 3) != nil {
+		t.Errorf("expected only alice to be left, got %d names", g.Len())
+	}
+}
+
+func TestGridAt(t *testing.T) {
+	g := NewGrid(4)
+	g.Insert("alice", Location3{X: 3, Y: 4})
+	g.Insert("bob", Location3{X: 3.4, Y: 3.6})
+	g.Insert("carol", Location3{X: 3.6, Y: 4})
+
+	if names := g.At(tilemap.Point{X: 3, Y: 4}); !reflect.DeepEqual(names, []string{"alice", "bob"}) {
+		t.Errorf("expected alice and bob on (3,4), got %v", names)
+	}
+	if names := g.At(tilemap.Point{X: 0, Y: 0}); names != nil {
+		t.Errorf("expected (0,0) to be free, got %v", names)
+	}
+}
+
+func TestGridNearest(t *testing.T) {
+	g := NewGrid(2)
+	g.Insert("alice", Location3{X: 1, Y: 0})
+	g.Insert("bob", Location3{X: -1, Y: 0})
+	g.Insert("carol", Location3{X: 0, Y: 5})
+	g.Insert("dave", Location3{X: 50, Y: 50})
+
+	cases := []struct {
+		at    Location3
+		n     int
+		names []string
+	}{
+		{Location3{}, 1, []string{"alice"}},
+		{Location3{}, 3, []string{"alice", "bob", "carol"}},
+		{Location3{}, 10, []string{"alice", "bob", "carol", "dave"}},
+		{Location3{X: 40, Y: 40}, 1, []string{"dave"}},
+		{Location3{X: 0.1, Y: 4}, 2, []string{"carol", "alice"}},
+		{Location3{}, 0, nil},
+	}
+	for _, c := range cases {
+		names := g.Nearest(c.at, c.n)
+		if !reflect.DeepEqual(names, c.names) {
+			t.Errorf("Nearest(%v, %d) = %v, expected %v", c.at, c.n, names, c.names)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package gamestate
+
+import (
+	"fmt"
+
+	"github.com/tilegame/gameserver/tilemap"
+)
+
+// Occupancy is the rule for players walking into each other.
+type Occupancy int
+
+const (
+	// Passable lets players walk through each other, and share tiles.
+	Passable Occupancy = iota
+
+	// Exclusive only allows one player on a tile.  A player whose next
+	// step is taken waits until it is free.  Players who are each
+	// stepping onto the tile of the next one, in a loop of three or
+	// more, all move at once.  Two players walking straight into each
+	// other would have to pass through each other, so they wait
+	// forever, or until one of them is sent somewhere else.
+	Exclusive
+
+	// Swap is like Exclusive, except that two players stepping onto
+	// each other's tile trade places.
+	Swap
+)
+
+var occupancyNames = []string{"passable", "exclusive", "swap"}
+
+func (o Occupancy) String() string {
+	if o < 0 || int(o) >= len(occupancyNames) {
+		return fmt.Sprintf("Occupancy(%d)", int(o))
+	}
+	return occupancyNames[o]
+}
+
+// Set parses the name of a rule, so that an Occupancy can be used as a
+// flag.Value.
+func (o *Occupancy) Set(s string) error {
+	for i, name := range occupancyNames {
+		if s == name {
+			*o = Occupancy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown occupancy rule %q; expected one of %v", s, occupancyNames)
+}
+
+// TileOccupancy is the rule used when players walk into each other.
+// Like the other settings of the games, it should be set before
+// NewGame is called.
+var TileOccupancy = Passable
+
+// stepper is a player that wants to take a step during a tick.
+type stepper struct {
+	p          *Player
+	here, next tilemap.Point
+}
+
+// moveAll moves every player one step along their path, following the
+// TileOccupancy.  The players are given their turns in order of their
+// ids, so when two players step onto the same tile, the one who joined
+// the game first gets it.  Players who are blocked get another turn
+// once everyone else has moved, in case the tile was freed up, and
+// then the loops of players blocking each other are moved together.
+func (g *Game) moveAll() {
+	players := g.sortedPlayers()
+	if TileOccupancy == Passable {
+		for _, p := range players {
+			g.updatePosition(p)
+		}
+		return
+	}
+
+	occupied := map[tilemap.Point]int{}
+	var waiting []*stepper
+	for _, p := range players {
+		here := p.CurrentPosition.Tile()
+		occupied[here]++
+		if next, ok := g.nextStep(p); ok {
+			waiting = append(waiting, &stepper{p, here, next.Tile()})
+		}
+	}
+
+	for moved := true; moved; {
+		moved = false
+		left := waiting[:0]
+		for _, s := range waiting {
+			if s.next != s.here && occupied[s.next] > 0 {
+				left = append(left, s)
+				continue
+			}
+			occupied[s.here]--
+			occupied[s.next]++
+			g.step(s.p)
+			moved = true
+		}
+		waiting = left
+	}
+	waiting = g.rotateLoops(waiting, occupied)
+
+	// a player whose destination is taken by someone who isn't going
+	// anywhere gives up, instead of waiting forever.
+	for _, s := range waiting {
+		if s.next == s.p.TargetPosition.Tile() && g.settled(s.next) {
+			s.p.TargetPosition = s.p.CurrentPosition
+			s.p.Path = nil
+		}
+	}
+}
+
+// rotateLoops moves the players that are each stepping onto the tile
+// of the next one, all the way around a loop, and returns the rest.
+// Every tile in a loop stays taken, so nobody else is affected.  Loops
+// of two players are only moved by the Swap rule, since they would
+// have to walk through each other.
+func (g *Game) rotateLoops(waiting []*stepper, occupied map[tilemap.Point]int) []*stepper {
+	shortest := 3
+	if TileOccupancy == Swap {
+		shortest = 2
+	}
+	byTile := make(map[tilemap.Point]*stepper, len(waiting))
+	for _, s := range waiting {
+		if s.next != s.here && occupied[s.here] == 1 {
+			byTile[s.here] = s
+		}
+	}
+	moved := map[*stepper]bool{}
+	for _, s := range waiting {
+		if moved[s] || byTile[s.here] != s {
+			continue
+		}
+		loop := []*stepper{s}
+		seen := map[*stepper]bool{s: true}
+		next := byTile[s.next]
+		for next != nil && !seen[next] {
+			loop = append(loop, next)
+			seen[next] = true
+			next = byTile[next.next]
+		}
+		if next != s || len(loop) < shortest {
+			continue
+		}
+		for _, l := range loop {
+			g.step(l.p)
+			moved[l] = true
+		}
+	}
+	var left []*stepper
+	for _, s := range waiting {
+		if !moved[s] {
+			left = append(left, s)
+		}
+	}
+	return left
+}
+
+// settled checks if the players on a tile have nowhere else to go.
+func (g *Game) settled(tile tilemap.Point) bool {
+	for _, name := range g.index.At(tile) {
+		if len(g.playerMap[name].Path) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// free checks if a new player can be placed on a tile.
+func (g *Game) free(x, y int) bool {
+	if !g.world.Walkable(x, y) {
+		return false
+	}
+	return TileOccupancy == Passable || len(g.index.At(tilemap.Point{X: x, Y: y})) == 0
+}
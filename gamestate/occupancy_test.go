@@ -0,0 +1,144 @@
+package gamestate
+
+import (
+	"testing"
+
+	"github.com/tilegame/gameserver/tilemap"
+)
+
+// newTestWorld creates a game without a Game Hub, so that a test can
+// place the players and call tick itself.
+func newTestWorld(t *testing.T, rule Occupancy) *Game {
+	old := TileOccupancy
+	TileOccupancy = rule
+	t.Cleanup(func() { TileOccupancy = old })
+	return &Game{
+		playerMap: map[string]*Player{},
+		index:     NewGrid(IndexCellSize),
+		world:     tilemap.NewRoom("test", 10, 10),
+	}
+}
+
+// place adds a player at (x,y), and sends them to (tx,ty).
+func place(t *testing.T, g *Game, name string, x, y, tx, ty int) *Player {
+	p, err := g.addPlayer(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.CurrentPosition = LocationOf(tilemap.Point{X: x, Y: y})
+	g.index.Insert(name, p.CurrentPosition)
+	if err := g.movePlayer(MoveOrder{name, tilemap.Point{X: tx, Y: ty}}); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func expectAt(t *testing.T, p *Player, x, y int) {
+	t.Helper()
+	if here := p.CurrentPosition.Tile(); here != (tilemap.Point{X: x, Y: y}) {
+		t.Errorf("%s is at %v, expected (%d,%d)", p.Name, here, x, y)
+	}
+}
+
+func TestPassablePlayers(t *testing.T) {
+	g := newTestWorld(t, Passable)
+	alice := place(t, g, "alice", 3, 5, 4, 5)
+	bob := place(t, g, "bob", 5, 5, 4, 5)
+	g.tick()
+	expectAt(t, alice, 4, 5)
+	expectAt(t, bob, 4, 5)
+}
+
+func TestExclusiveTiles(t *testing.T) {
+	g := newTestWorld(t, Exclusive)
+
+	// the player who joined first gets the tile, and the other one
+	// gives up, since alice isn't going to move out of the way.
+	alice := place(t, g, "alice", 3, 5, 4, 5)
+	bob := place(t, g, "bob", 5, 5, 4, 5)
+	g.tick()
+	expectAt(t, alice, 4, 5)
+	expectAt(t, bob, 5, 5)
+	if len(bob.Path) != 0 || bob.TargetPosition != bob.CurrentPosition {
+		t.Errorf("bob should have given up, but is going to %v", bob.TargetPosition)
+	}
+
+	// a player can follow right behind someone who is moving out of
+	// the way, no matter who goes first.
+	carol := place(t, g, "carol", 1, 2, 8, 2)
+	dave := place(t, g, "dave", 2, 2, 8, 2)
+	g.tick()
+	expectAt(t, carol, 2, 2)
+	expectAt(t, dave, 3, 2)
+}
+
+func TestHeadOnCollision(t *testing.T) {
+	for _, tc := range []struct {
+		rule       Occupancy
+		alice, bob int
+	}{
+		{Exclusive, 3, 4},
+		{Swap, 4, 3},
+	} {
+		g := newTestWorld(t, tc.rule)
+		alice := place(t, g, "alice", 3, 5, 4, 5)
+		bob := place(t, g, "bob", 4, 5, 3, 5)
+		g.tick()
+		expectAt(t, alice, tc.alice, 5)
+		expectAt(t, bob, tc.bob, 5)
+	}
+}
+
+func TestExclusiveSpawn(t *testing.T) {
+	g := newTestWorld(t, Exclusive)
+	a, _ := g.addPlayer("alice")
+	b, err := g.addPlayer("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.CurrentPosition == b.CurrentPosition {
+		t.Errorf("both players spawned at %v", a.CurrentPosition)
+	}
+}
+
+func TestOccupancyFlag(t *testing.T) {
+	var o Occupancy
+	if err := o.Set("swap"); err != nil || o != Swap || o.String() != "swap" {
+		t.Errorf("Set(swap) gave %v, %v", o, err)
+	}
+	if err := o.Set("ghost"); err == nil {
+		t.Error("expected an error for an unknown rule")
+	}
+}
+
+// TestLoops checks that players who are each stepping onto the tile of
+// the next one all move together, unless there are only two of them.
+func TestLoops(t *testing.T) {
+	for _, tc := range []struct {
+		rule  Occupancy
+		loop  [][2]int // where each player stands, in order.
+		moved bool
+	}{
+		{Exclusive, [][2]int{{3, 3}, {4, 3}}, false},
+		{Swap, [][2]int{{3, 3}, {4, 3}}, true},
+		{Exclusive, [][2]int{{3, 3}, {4, 3}, {4, 4}}, true},
+		{Exclusive, [][2]int{{3, 3}, {4, 3}, {4, 4}, {3, 4}}, true},
+		{Swap, [][2]int{{3, 3}, {4, 3}, {4, 4}, {3, 4}}, true},
+	} {
+		g := newTestWorld(t, tc.rule)
+		players := make([]*Player, len(tc.loop))
+		for i, here := range tc.loop {
+			next := tc.loop[(i+1)%len(tc.loop)]
+			name := string(rune('a' + i))
+			players[i] = place(t, g, name, here[0], here[1], next[0], next[1])
+		}
+		g.tick()
+		for i, p := range players {
+			want := tc.loop[i]
+			if tc.moved {
+				want = tc.loop[(i+1)%len(tc.loop)]
+			}
+			expectAt(t, p, want[0], want[1])
+		}
+	}
+}
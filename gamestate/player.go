@@ -0,0 +1,84 @@
+package gamestate
+
+import (
+	"math"
+	"time"
+
+	"github.com/tilegame/gameserver/tilemap"
+)
+
+// Player is a character in the game world.  The JSON field names match
+// the player list that the clients have always received.
+type Player struct {
+	ID              int         `json:"PlayerId"`
+	Name            string      `json:"-"`
+	CurrentPosition Location3   `json:"CurrentPos"`
+	TargetPosition  Location3   `json:"TargetPos"`
+	Path            []Location3 `json:",omitempty"`
+	lastActive      time.Time
+}
+
+// Location3 is a position in the game world.  X and Y are measured in
+// tiles, and Z is the height above the floor.
+type Location3 struct {
+	X, Y, Z float64
+}
+
+// LocationOf returns the location of the center of a tile.
+func LocationOf(p tilemap.Point) Location3 {
+	return Location3{X: float64(p.X), Y: float64(p.Y)}
+}
+
+// Tile returns the tile that contains the location.
+func (l Location3) Tile() tilemap.Point {
+	return tilemap.Point{
+		X: int(math.Floor(l.X + 0.5)),
+		Y: int(math.Floor(l.Y + 0.5)),
+	}
+}
+
+// copy returns a Player that doesn't share any memory with p.
+func (p *Player) copy() Player {
+	c := *p
+	if p.Path != nil {
+		c.Path = make([]Location3, len(p.Path))
+		copy(c.Path, p.Path)
+	}
+	return c
+}
+
+// updatePosition moves the player one step along their path.
+func (g *Game) updatePosition(p *Player) {
+	if _, ok := g.nextStep(p); ok {
+		g.step(p)
+	}
+}
+
+// nextStep returns where the player is about to step.  If that step
+// has become blocked since the path was found, a new path is found
+// instead.  If there isn't one, the player gives up and stays where
+// they are.
+func (g *Game) nextStep(p *Player) (Location3, bool) {
+	if len(p.Path) == 0 {
+		return Location3{}, false
+	}
+	next := p.Path[0].Tile()
+	if !g.world.Walkable(next.X, next.Y) {
+		path, err := g.findPath(p.CurrentPosition.Tile(), p.TargetPosition.Tile())
+		if err != nil || len(path) == 0 {
+			p.TargetPosition = p.CurrentPosition
+			p.Path = nil
+			return Location3{}, false
+		}
+		p.Path = path
+	}
+	return p.Path[0], true
+}
+
+// step moves the player to the next location on their path, and keeps
+// the index up to date.
+func (g *Game) step(p *Player) {
+	p.CurrentPosition = p.Path[0]
+	p.Path = p.Path[1:]
+	g.index.Insert(p.Name, p.CurrentPosition)
+}
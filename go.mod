@@ -4,7 +4,9 @@ go 1.15
 
 require (
 	github.com/fractalbach/fractalnet v0.0.0-20180903105958-adc953aff8bb
+	github.com/fxamacker/cbor/v2 v2.5.0
 	github.com/gorilla/securecookie v1.1.1
 	github.com/gorilla/websocket v1.4.2
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 )
@@ -13,10 +13,14 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 
 	"github.com/tilegame/gameserver/cookiez"
+	"github.com/tilegame/gameserver/cookiez/accounts"
+	"github.com/tilegame/gameserver/cookiez/registrar"
 	"github.com/tilegame/gameserver/echoserver"
-	"github.com/tilegame/gameserver/wshandle"
+	"github.com/tilegame/gameserver/gamestate"
+	"github.com/tilegame/gameserver/tilemap"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -50,27 +54,128 @@ INFORMATION:
       /     routes to files if the file server is enabled.
       /*    routes to any file in the directory and subdirectories.
       /ws   routes to the websocket connection.  Has no files.
+            The handshake needs the session cookie from /cookie, and
+            is refused with 401 Unauthorized without one.
+            Messages are commands, written either as JSON-RPC 2.0:
+              {"jsonrpc": "2.0", "method": "move",
+               "params": ["alice", 3, 4], "id": 1}
+            as JSON:
+              {"Name": "move", "Args": ["alice", 3, 4]}
+            or with the function syntax:
+              move("alice", 3, 4)
+            Arguments can also be given by name:
+              move("alice", x=3, y=4)
+            Clients can ask for the "msgpack" or "cbor" websocket
+            subprotocol to send and receive the same messages in a
+            binary encoding; this also works on /ws/echo.
+            Send help() for the list of commands.  The commands
+            tagged "player", like move, need the client to have
+            added a player with add("alice") first, and only work
+            on that player.  The game's own methods are in the
+            "game" namespace, like
+              game.movePlayer("alice", 3, 4)
+            After every tick, each client is sent the players:
+              {"kind": "state", "result": {"tick": 12, "full": true,
+               "players": {"alice": {...}}}}
+            Acknowledging a tick with a notification like
+              {"jsonrpc": "2.0", "method": "ack", "params": [12]}
+            makes the next states deltas from that tick instead:
+              {"kind": "state", "result": {"tick": 14, "base": 12,
+               "players": {<added or changed>}, "removed": ["bob"]}}
+            A full state is sent again if the client falls behind.
+            Once a client has added a player, it is only sent the
+            players within -radius tiles of its own.
+      /openrpc
+            describes the /ws commands as an OpenRPC document, which
+            is also returned by the rpc.discover command.
+
+ Maps
+ ----
+  The world map can be loaded from a directory with the flag:
+    -maps <directory>
+  Maps are exported from the Tiled editor as JSON (.tmj or .json),
+  or written as plain grids of tile kinds (.csv).  The map that the
+  players start on is chosen by its file name, without the extension:
+    -map <name>
+  If no directory is given, the players start in an empty room.
+  By default, players walk through each other.  With
+    -occupancy exclusive
+  only one player fits on a tile, and the others wait their turn,
+  except for a loop of three or more players stepping onto each
+  other's tiles, who all move at once;
+    -occupancy swap
+  also lets two players walking into each other trade places.
 
  Input and Output
  ----------------
   If the -io flag is used, then stdin and stdout will be enabled.
   Stdin will be scanned line by line (will wait for each line), so
-  commands can be sent interactively.
+  commands can be sent interactively.  These are admin commands,
+  written in the function syntax:
+    disable("chat")   turns off a /ws command.
+    enable("chat")    turns it back on.
+    commands()        lists the /ws commands.
+    rollkey()         signs new cookies with a new key.
+    help()            lists the admin commands.
+
+ Sessions
+ --------
+  Visiting /cookie logs in as a guest.  Each visit to /cookie, each
+  /ws handshake, and the commands sent over /ws keep the session going
+  for another -session-idle, up to -session-max after logging in.
+  Visits to /cookie and /ws also renew the cookie for -session.
+  Sessions are kept in memory, unless they are saved to a file with
+    -sessions-file <file>
+  which, along with -cookie-keys, keeps players logged in when the
+  server restarts.
+
+ Accounts
+ --------
+  Players who want to keep their name can sign up for an account by
+  POSTing a form with a username and password to /signup, and log in
+  again later the same way with /login.  Both hand out the same
+  session cookie as /cookie, which is still there for guests.  The
+  accounts are kept in memory, unless they are saved to a file with
+    -accounts <file>
+  Only bcrypt hashes of the passwords are kept.
+
+ Cookie Keys
+ -----------
+  The session cookies are signed with secret keys.  To keep them
+  working after a restart, or across servers, give the keys in a file:
+    -cookie-keys <file>
+  which is created with a new key if it doesn't exist, or in the
+  environment variable TILEGAME_COOKIE_KEYS.  Keys are written as
+  hash:block in hex, newest first, separated by commas or lines.
+  rollkey() adds a new key and saves it to the file; cookies signed
+  with the previous key keep working until the next roll.
 
 OPTIONS:
 `
 
 const (
-	HelpAddress = "Host Address and Port to listen on."
-	HelpTLS     = "Enable TLS and AutoCert for LetsEncrypt."
-	HelpIndex   = "Homepage file. Only matters if file server is enabled."
-	HelpIO      = "Enable Stdin input and Stdout output."
-	HelpFiles   = "Enables the File Server"
+	HelpAddress      = "Host Address and Port to listen on."
+	HelpTLS          = "Enable TLS and AutoCert for LetsEncrypt."
+	HelpIndex        = "Homepage file. Only matters if file server is enabled."
+	HelpIO           = "Enable Stdin input and Stdout output."
+	HelpFiles        = "Enables the File Server"
+	HelpMaps         = "Directory containing the tile maps."
+	HelpMap          = "Name of the map that players start on."
+	HelpRadius       = "How far, in tiles, clients see from their player. 0 shows everyone."
+	HelpSession      = "How long browsers keep the session cookie each time it is handed out."
+	HelpSessionIdle  = "How long a session lasts without any activity."
+	HelpSessionMax   = "How long a session can last after logging in, no matter what."
+	HelpSessionsFile = "File that the sessions are saved to. By default, they are only kept in memory."
+	HelpKeys         = "File with the secret keys of the session cookies. Created if missing."
+	HelpAccounts     = "File that the accounts are saved to. By default, they are only kept in memory."
+	HelpOccupancy    = "What happens when players walk into each other: passable, exclusive or swap."
 )
 
 const (
 	DefaultAddress = "localhost:8080"
 	DefaultIndex   = "index.html"
+	DefaultMap     = "world"
+	DefaultRadius  = 24
 )
 
 var (
@@ -79,22 +184,44 @@ var (
 	usingFiles     bool
 	addr           string
 	index          string
+	mapsDir        string
+	startMap       string
+	keyFile        string
+	sessionsFile   string
+	accountsFile   string
+	lifetime       cookiez.Lifetime
 )
 
+// cookieKeysEnv is the environment variable that can hold the secret
+// keys of the session cookies, when there is no -cookie-keys file.
+const cookieKeysEnv = "TILEGAME_COOKIE_KEYS"
+
 var cookieServer = cookiez.NewCookieServer()
 
+// game is the authoritative state of the game world, shared by all of
+// the websocket endpoints.  It is made by main, after the flags that
+// change how the game runs, like -occupancy, have been read, since the
+// game reads them while it runs.
+var game *gamestate.Game
+
 var endpoints = map[string]func(http.ResponseWriter, *http.Request){
 	"/ws":       serveWebSocket,
 	"/ws/echo":  serveWebSocketEcho,
 	"/cookie":   cookieServer.ServeCookies,
+	"/signup":   cookieServer.ServeSignup,
+	"/login":    cookieServer.ServeLogin,
 	"/sessions": cookieServer.HandleInfo,
+	"/openrpc":  commandCenter.ServeDiscover,
 }
 
 var endpointDescriptions = map[string]string{
-	"/ws":       "Main websocket connection for game (not implemented yet)",
+	"/ws":       "Main websocket connection for game",
 	"/ws/echo":  "echo server used for testing connection speeds",
 	"/cookie":   "generates and/or validates new cookies for clients",
+	"/signup":   "makes an account from a POSTed username and password",
+	"/login":    "logs in to an account with a POSTed username and password",
 	"/sessions": "generates a list of active sessions",
+	"/openrpc":  "describes the /ws commands as an OpenRPC document",
 }
 
 var (
@@ -109,6 +236,16 @@ func init() {
 	flag.BoolVar(&useStdinStdout, "io", false, HelpIO)
 	flag.BoolVar(&usingTLS, "tls", false, HelpTLS)
 	flag.BoolVar(&usingFiles, "serve-files", false, HelpFiles)
+	flag.StringVar(&mapsDir, "maps", "", HelpMaps)
+	flag.StringVar(&startMap, "map", DefaultMap, HelpMap)
+	flag.Float64Var(&viewRadius, "radius", DefaultRadius, HelpRadius)
+	flag.Var(&gamestate.TileOccupancy, "occupancy", HelpOccupancy)
+	flag.StringVar(&keyFile, "cookie-keys", "", HelpKeys)
+	flag.StringVar(&sessionsFile, "sessions-file", "", HelpSessionsFile)
+	flag.StringVar(&accountsFile, "accounts", "", HelpAccounts)
+	flag.DurationVar(&lifetime.Duration, "session", cookiez.DefaultLifetime.Duration, HelpSession)
+	flag.DurationVar(&lifetime.IdleTimeout, "session-idle", cookiez.DefaultLifetime.IdleTimeout, HelpSessionIdle)
+	flag.DurationVar(&lifetime.MaxLifetime, "session-max", cookiez.DefaultLifetime.MaxLifetime, HelpSessionMax)
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr, HelpMessage)
 		flag.PrintDefaults()
@@ -141,12 +278,97 @@ func init() {
 
 func main() {
 	flag.Parse()
+	game = gamestate.NewGame()
+	if mapsDir != "" {
+		loadMaps()
+	}
+	loadCookieKeys()
+	if sessionsFile != "" {
+		loadSessions()
+	}
+	if accountsFile != "" {
+		loadAccounts()
+	}
+	cookieServer.SetLifetime(lifetime)
+	echoserver.Start(game)
+	startGameEndpoint()
 	if useStdinStdout {
 		go inputLoop()
 	}
 	runServer()
 }
 
+// loadMaps reads the maps from the -maps directory, and hands the
+// starting map to the game.  The server refuses to start if any of the
+// maps are malformed, since that is much easier to notice than players
+// walking through walls.
+func loadMaps() {
+	maps, err := tilemap.LoadDir(mapsDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, ok := maps[startMap]
+	if !ok {
+		names := make([]string, 0, len(maps))
+		for name := range maps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		log.Fatalf("map %q not found in %s; available maps: %v",
+			startMap, mapsDir, names)
+	}
+	log.Printf("loaded %d maps from %s, starting on %q",
+		len(maps), mapsDir, startMap)
+	err = game.SetMap(m)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadCookieKeys gives the cookie server the keys from the -cookie-keys
+// file, or from the environment.  Without either of them, the cookies
+// only work until the server restarts.
+func loadCookieKeys() {
+	if keyFile != "" {
+		if err := cookieServer.UseKeyFile(keyFile); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("loaded %d cookie keys from %s", len(cookieServer.Keys()), keyFile)
+		return
+	}
+	if s := os.Getenv(cookieKeysEnv); s != "" {
+		keys, err := cookiez.ParseKeys(s)
+		if err != nil {
+			log.Fatalf("%s: %v", cookieKeysEnv, err)
+		}
+		cookieServer.SetKeys(keys...)
+		log.Printf("loaded %d cookie keys from $%s", len(keys), cookieKeysEnv)
+		return
+	}
+	log.Println("using a random cookie key; sessions end when the server stops.")
+}
+
+// loadSessions keeps the sessions in the -sessions-file, so that the
+// players stay logged in when the server restarts.
+func loadSessions() {
+	store, err := registrar.OpenFile(sessionsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cookieServer.SetStore(store)
+	log.Printf("loaded %d sessions from %s", len(store.List()), sessionsFile)
+}
+
+// loadAccounts keeps the accounts in the -accounts file.
+func loadAccounts() {
+	store, err := accounts.OpenFile(accountsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cookieServer.SetAccounts(store)
+	log.Printf("loaded %d accounts from %s", store.Len(), accountsFile)
+}
+
 func inputLoop() {
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
@@ -162,6 +384,8 @@ func handleStdinCommand(line string) {
 	case "quit", "exit", "goodbye", "stop":
 		fmt.Println("Shutting down server...")
 		log.Fatal("Shutting down server by request from stdin.")
+	default:
+		handleAdminCommand(line)
 	}
 }
 
@@ -219,9 +443,6 @@ func serveMinimal(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// TODO: move this somewhere other than server.go
-var clientroom = wshandle.NewClientRoom()
-
 func serveWebSocket(w http.ResponseWriter, r *http.Request) {
 	clientroom.Handle(w, r)
 }
@@ -0,0 +1,24 @@
+/*
+Package tilemap describes the layout of the game world.
+
+A Map is a grid of tiles, split into a Floor layer and an Objects
+layer.  Each tile has a Kind (grass, water, wall, etc.), and a flag
+that determines whether players are allowed to walk on it.  The game
+server consults the map before moving a player, which is how
+collisions are detected.
+
+Maps are plain data, so they can be encoded as JSON and sent to the
+clients when they join the game.
+
+
+Loading Maps
+
+Level designers author maps in the Tiled editor (https://www.mapeditor.org)
+and export them as JSON.  Those files, along with plain CSV grids, can be
+loaded one at a time with LoadFile, or all at once with LoadDir.
+	maps, err := tilemap.LoadDir("maps")
+
+Malformed files are rejected with an error that names the file and,
+where possible, the layer, object or cell that caused the problem.
+*/
+package tilemap
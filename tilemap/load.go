@@ -0,0 +1,100 @@
+package tilemap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadCSV decodes a plain grid of tiles, where each row of the file is
+// a row of the map.  Every cell is either the name of a Kind, like
+// "grass" or "wall", or its number.  All tiles are placed on the
+// Floor layer, and are blocked according to their kind.
+func ReadCSV(r io.Reader, name string) (*Map, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no rows found")
+	}
+
+	m := New(name, len(rows[0]), len(rows))
+	for y, row := range rows {
+		for x, cell := range row {
+			k, err := ParseKind(cell)
+			if err != nil {
+				return nil, fmt.Errorf("row %d, column %d: %v", y+1, x+1, err)
+			}
+			m.Set(Floor, x, y, k)
+		}
+	}
+	return m, m.Validate()
+}
+
+// LoadFile reads a single map.  The format is chosen by the file
+// extension: ".tmj" and ".json" are Tiled maps, and ".csv" is a
+// plain grid.  The name of the map is the name of the file without
+// its extension.
+func LoadFile(path string) (*Map, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ext := strings.ToLower(filepath.Ext(path))
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	var m *Map
+	switch ext {
+	case ".tmj", ".json":
+		m, err = ReadTiled(f, name)
+	case ".csv":
+		m, err = ReadCSV(f, name)
+	default:
+		err = fmt.Errorf("unknown map format %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tilemap: %s: %v", path, err)
+	}
+	return m, nil
+}
+
+// LoadDir reads every map in a directory, and returns them by name.
+// Files with other extensions are skipped.  The first malformed map
+// stops the loading and its error is returned.
+func LoadDir(dir string) (map[string]*Map, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	maps := map[string]*Map{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(f.Name())) {
+		case ".tmj", ".json", ".csv":
+		default:
+			continue
+		}
+		m, err := LoadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := maps[m.Name]; ok {
+			return nil, fmt.Errorf("tilemap: %s: duplicate map name %q",
+				dir, m.Name)
+		}
+		maps[m.Name] = m
+	}
+	return maps, nil
+}
@@ -0,0 +1,176 @@
+package tilemap
+
+import (
+	"strings"
+	"testing"
+)
+
+const exampleTiled = `{
+  "width": 4, "height": 3, "tilewidth": 16, "tileheight": 16,
+  "orientation": "orthogonal", "infinite": false,
+  "tilesets": [{
+    "firstgid": 1,
+    "tiles": [
+      {"id": 0, "type": "grass"},
+      {"id": 1, "type": "water"},
+      {"id": 2, "type": "sand", "properties": [
+        {"name": "collides", "type": "bool", "value": true}
+      ]},
+      {"id": 3, "type": "tree"},
+      {"id": 4, "properties": [
+        {"name": "collides", "type": "bool", "value": true}
+      ]}
+    ]
+  }],
+  "layers": [
+    {"type": "tilelayer", "name": "floor", "width": 4, "height": 3,
+     "data": [1, 1, 1, 1,
+              1, 2, 3, 1,
+              1, 1, 1, 1]},
+    {"type": "tilelayer", "name": "collision", "width": 4, "height": 3,
+     "data": [0, 0, 0, 0,
+              0, 0, 0, 0,
+              0, 0, 0, 5]},
+    {"type": "objectgroup", "name": "things", "objects": [
+      {"name": "fence", "x": 0, "y": 32, "width": 32, "height": 16,
+       "properties": [{"name": "collides", "type": "bool", "value": true}]},
+      {"name": "oak", "gid": 4, "x": 48, "y": 16, "width": 16, "height": 16}
+    ]}
+  ]
+}`
+
+func TestReadTiled(t *testing.T) {
+	m, err := ReadTiled(strings.NewReader(exampleTiled), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Width != 4 || m.Height != 3 {
+		t.Fatalf("wrong dimensions: %dx%d", m.Width, m.Height)
+	}
+
+	cases := []struct {
+		x, y     int
+		floor    Kind
+		object   Kind
+		walkable bool
+	}{
+		{0, 0, Grass, None, true},
+		{1, 1, Water, None, false},
+		{2, 1, Sand, None, false},
+		{3, 0, Grass, Tree, false},
+		{0, 2, Grass, None, false},
+		{1, 2, Grass, None, false},
+		{2, 2, Grass, None, true},
+		{3, 2, Grass, None, false},
+	}
+	for _, c := range cases {
+		if k := m.At(Floor, c.x, c.y); k != c.floor {
+			t.Errorf("(%d,%d) floor = %v, expected %v", c.x, c.y, k, c.floor)
+		}
+		if k := m.At(Objects, c.x, c.y); k != c.object {
+			t.Errorf("(%d,%d) object = %v, expected %v", c.x, c.y, k, c.object)
+		}
+		if w := m.Walkable(c.x, c.y); w != c.walkable {
+			t.Errorf("(%d,%d) walkable = %v, expected %v",
+				c.x, c.y, w, c.walkable)
+		}
+	}
+}
+
+func TestReadTiledErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		msg  string
+	}{
+		{"syntax", `{"width": 2,`, "invalid JSON"},
+		{"infinite", `{"width": 2, "height": 2, "infinite": true}`, "infinite"},
+		{"size", `{"width": 0, "height": 2}`, "dimensions"},
+		{"iso", `{"width": 1, "height": 1, "orientation": "isometric"}`,
+			"orientation"},
+		{"short", `{"width": 2, "height": 2, "layers": [
+			{"type": "tilelayer", "name": "floor", "data": [1, 1, 1]}]}`,
+			"expected 4 tiles, got 3"},
+		{"base64", `{"width": 1, "height": 1, "layers": [
+			{"type": "tilelayer", "name": "floor", "encoding": "base64",
+			 "data": "AQAAAA=="}]}`,
+			"unsupported layer encoding"},
+		{"zlib", `{"width": 1, "height": 1, "layers": [
+			{"type": "tilelayer", "name": "floor", "compression": "zlib",
+			 "data": [1]}]}`,
+			"unsupported layer encoding"},
+		{"no tileset", `{"width": 1, "height": 1, "layers": [
+			{"type": "tilelayer", "name": "floor", "data": [2]}]}`,
+			`layer "floor": cell (0,0): tile id 2 is not in any tileset`},
+		{"untyped", `{"width": 1, "height": 1,
+			"tilesets": [{"firstgid": 1, "tiles": [{"id": 1}]}],
+			"layers": [{"type": "tilelayer", "name": "floor", "data": [2]}]}`,
+			`layer "floor": cell (0,0): tile id 2 has no type or "collides" property`},
+		{"untyped object", `{"width": 1, "height": 1, "tilewidth": 16,
+			"tileheight": 16, "layers": [{"type": "objectgroup",
+			"name": "things", "objects": [{"name": "rock", "gid": 7,
+			"x": 0, "y": 16}]}]}`,
+			`layer "things": object "rock": tile id 7 is not in any tileset`},
+		{"property", `{"width": 1, "height": 1, "layers": [
+			{"type": "tilelayer", "name": "floor", "data": [1],
+			 "properties": [{"name": "collides", "value": "yes"}]}]}`,
+			"must be a bool"},
+		{"external", `{"width": 1, "height": 1,
+			"tilesets": [{"firstgid": 1, "source": "terrain.tsx"}]}`,
+			"external tileset"},
+	}
+	for _, c := range cases {
+		_, err := ReadTiled(strings.NewReader(c.in), c.name)
+		if err == nil {
+			t.Errorf("%s: expected an error", c.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%s: expected error containing %q, got %q",
+				c.name, c.msg, err)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	in := `# a tiny island
+water, water, water
+water, grass, 3
+water, water, water
+`
+	m, err := ReadCSV(strings.NewReader(in), "island")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Width != 3 || m.Height != 3 {
+		t.Fatalf("wrong dimensions: %dx%d", m.Width, m.Height)
+	}
+	if !m.Walkable(1, 1) || m.Walkable(0, 0) {
+		t.Error("only the grass tile should be walkable.")
+	}
+	if k := m.At(Floor, 2, 1); k != Sand {
+		t.Errorf("expected numbered tile to be sand, got %v", k)
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	cases := []struct {
+		in  string
+		msg string
+	}{
+		{"", "no rows"},
+		{"grass, grass\ngrass", "wrong number of fields"},
+		{"grass, lava", "row 1, column 2"},
+	}
+	for _, c := range cases {
+		_, err := ReadCSV(strings.NewReader(c.in), "bad")
+		if err == nil {
+			t.Errorf("%q: expected an error", c.in)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%q: expected error containing %q, got %q",
+				c.in, c.msg, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package tilemap
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+)
+
+// ErrNoPath is returned by FindPath when the target can't be reached
+// from the starting point.
+var ErrNoPath = errors.New("no path to target")
+
+// kindCosts is how expensive it is to walk onto a tile of each kind.
+// Kinds that aren't listed cost 1.
+var kindCosts = map[Kind]float64{
+	Sand: 2,
+	Dirt: 1.5,
+}
+
+// Point is the position of a tile on a map.
+type Point struct {
+	X int
+	Y int
+}
+
+// PathOptions change the way that FindPath searches the map.
+type PathOptions struct {
+	// Diagonal allows moving to the 4 diagonal neighbors of a tile,
+	// in addition to the 4 orthogonal neighbors.  Diagonal moves
+	// can't cut the corner of a blocked tile.
+	Diagonal bool
+
+	// Cost overrides the movement cost of entering the tile at
+	// (x,y).  If it is nil, Map.Cost is used.
+	Cost func(x, y int) float64
+}
+
+// Cost returns how expensive it is to walk onto the tile at (x,y).
+// The cost is never less than 1, and is decided by the most expensive
+// kind of tile on either layer.
+func (m *Map) Cost(x, y int) float64 {
+	cost := 1.0
+	for _, k := range []Kind{m.At(Floor, x, y), m.At(Objects, x, y)} {
+		if c, ok := kindCosts[k]; ok && c > cost {
+			cost = c
+		}
+	}
+	return cost
+}
+
+var (
+	orthogonal = []Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	diagonal   = []Point{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+)
+
+// FindPath uses A* to find the cheapest path between two tiles.  The
+// returned path does not include the starting tile, but does include
+// the target, so each element is the next step to take.  If both
+// points are the same, the path is empty.  ErrNoPath is returned when
+// the target is blocked or can't be reached.
+func (m *Map) FindPath(from, to Point, opts PathOptions) ([]Point, error) {
+	if from == to {
+		return []Point{}, nil
+	}
+	if !m.Walkable(to.X, to.Y) {
+		return nil, ErrNoPath
+	}
+	cost := opts.Cost
+	if cost == nil {
+		cost = m.Cost
+	}
+	estimate := manhattan
+	if opts.Diagonal {
+		estimate = octile
+	}
+
+	cameFrom := map[Point]Point{}
+	spent := map[Point]float64{from: 0}
+	open := &pathQueue{}
+	heap.Push(open, &pathNode{p: from, priority: estimate(from, to)})
+
+	for open.Len() > 0 {
+		current := heap.Pop(open).(*pathNode)
+		if current.p == to {
+			return rebuildPath(cameFrom, from, to), nil
+		}
+		if current.spent > spent[current.p] {
+			// a cheaper way to this tile was found after it was
+			// queued, so this entry is stale.
+			continue
+		}
+		for _, next := range m.neighbors(current.p, opts.Diagonal) {
+			step := cost(next.X, next.Y)
+			if next.X != current.p.X && next.Y != current.p.Y {
+				step *= math.Sqrt2
+			}
+			total := spent[current.p] + step
+			if old, seen := spent[next]; seen && old <= total {
+				continue
+			}
+			spent[next] = total
+			cameFrom[next] = current.p
+			heap.Push(open, &pathNode{
+				p:        next,
+				spent:    total,
+				priority: total + estimate(next, to),
+			})
+		}
+	}
+	return nil, ErrNoPath
+}
+
+// neighbors returns the walkable tiles next to p.
+func (m *Map) neighbors(p Point, withDiagonals bool) []Point {
+	out := make([]Point, 0, 8)
+	for _, d := range orthogonal {
+		if m.Walkable(p.X+d.X, p.Y+d.Y) {
+			out = append(out, Point{p.X + d.X, p.Y + d.Y})
+		}
+	}
+	if !withDiagonals {
+		return out
+	}
+	for _, d := range diagonal {
+		if m.Walkable(p.X+d.X, p.Y+d.Y) &&
+			m.Walkable(p.X+d.X, p.Y) &&
+			m.Walkable(p.X, p.Y+d.Y) {
+			out = append(out, Point{p.X + d.X, p.Y + d.Y})
+		}
+	}
+	return out
+}
+
+func rebuildPath(cameFrom map[Point]Point, from, to Point) []Point {
+	var path []Point
+	for p := to; p != from; p = cameFrom[p] {
+		path = append(path, p)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+func manhattan(a, b Point) float64 {
+	return math.Abs(float64(a.X-b.X)) + math.Abs(float64(a.Y-b.Y))
+}
+
+func octile(a, b Point) float64 {
+	dx := math.Abs(float64(a.X - b.X))
+	dy := math.Abs(float64(a.Y - b.Y))
+	return math.Max(dx, dy) + (math.Sqrt2-1)*math.Min(dx, dy)
+}
+
+// pathQueue is a priority queue of tiles waiting to be explored by
+// FindPath.  It satisfies heap.Interface.
+type pathQueue []*pathNode
+
+type pathNode struct {
+	p        Point
+	spent    float64
+	priority float64
+}
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(*pathNode)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
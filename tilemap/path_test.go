@@ -0,0 +1,101 @@
+package tilemap
+
+import "testing"
+
+// wallWithGap builds a 7x7 room with a wall down the middle column,
+// and a single gap at the bottom.
+//
+//	#######
+//	#..#..#
+//	#..#..#
+//	#..#..#
+//	#..#..#
+//	#.....#
+//	#######
+func wallWithGap() *Map {
+	m := NewRoom("gap", 7, 7)
+	for y := 1; y <= 4; y++ {
+		m.Set(Objects, 3, y, Wall)
+	}
+	return m
+}
+
+func checkPath(t *testing.T, m *Map, from Point, path []Point, diagonal bool) {
+	t.Helper()
+	prev := from
+	for _, p := range path {
+		if !m.Walkable(p.X, p.Y) {
+			t.Fatalf("path goes through a blocked tile %v: %v", p, path)
+		}
+		dx, dy := p.X-prev.X, p.Y-prev.Y
+		if dx < -1 || dx > 1 || dy < -1 || dy > 1 || (dx == 0 && dy == 0) {
+			t.Fatalf("path jumps from %v to %v: %v", prev, p, path)
+		}
+		if !diagonal && dx != 0 && dy != 0 {
+			t.Fatalf("diagonal step from %v to %v: %v", prev, p, path)
+		}
+		prev = p
+	}
+}
+
+func TestFindPathAroundWall(t *testing.T) {
+	m := wallWithGap()
+	from, to := Point{1, 1}, Point{5, 1}
+
+	path, err := m.FindPath(from, to, PathOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPath(t, m, from, path, false)
+	if len(path) != 12 {
+		t.Errorf("expected 12 steps, got %d: %v", len(path), path)
+	}
+	if path[len(path)-1] != to {
+		t.Errorf("path ends at %v instead of %v", path[len(path)-1], to)
+	}
+
+	path, err = m.FindPath(from, to, PathOptions{Diagonal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPath(t, m, from, path, true)
+	if len(path) >= 12 {
+		t.Errorf("diagonal path should be shorter, got %d: %v", len(path), path)
+	}
+}
+
+func TestFindPathCost(t *testing.T) {
+	// a strip of sand in the middle row makes it cheaper to walk
+	// around it than across it.
+	m := NewRoom("sand", 7, 5)
+	for x := 1; x <= 5; x++ {
+		m.Set(Floor, x, 2, Sand)
+	}
+	path, err := m.FindPath(Point{1, 2}, Point{5, 2}, PathOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range path[:len(path)-1] {
+		if p.Y == 2 {
+			t.Fatalf("path walked through the sand: %v", path)
+		}
+	}
+}
+
+func TestFindPathUnreachable(t *testing.T) {
+	m := wallWithGap()
+	m.Set(Objects, 3, 5, Wall)
+
+	_, err := m.FindPath(Point{1, 1}, Point{5, 1}, PathOptions{Diagonal: true})
+	if err != ErrNoPath {
+		t.Errorf("expected ErrNoPath, got %v", err)
+	}
+	_, err = m.FindPath(Point{1, 1}, Point{3, 2}, PathOptions{})
+	if err != ErrNoPath {
+		t.Errorf("expected ErrNoPath for a blocked target, got %v", err)
+	}
+	path, err := m.FindPath(Point{1, 1}, Point{1, 1}, PathOptions{})
+	if err != nil || len(path) != 0 {
+		t.Errorf("expected an empty path, got %v, %v", path, err)
+	}
+}
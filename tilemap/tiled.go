@@ -0,0 +1,318 @@
+package tilemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Tiled stores flipping and rotation flags in the highest bits of each
+// global tile id.  They have no effect on collisions, so they are
+// masked out.
+const tiledFlagMask = 0xF0000000
+
+// collidesProperty is the name of the custom property that the level
+// designers set in Tiled to mark tiles, layers or objects as solid.
+const collidesProperty = "collides"
+
+// The structures below mirror the parts of the Tiled JSON format
+// (https://doc.mapeditor.org/en/stable/reference/json-map-format/)
+// that the game server cares about.  Everything else is ignored.
+
+type tiledMap struct {
+	Width       int            `json:"width"`
+	Height      int            `json:"height"`
+	TileWidth   int            `json:"tilewidth"`
+	TileHeight  int            `json:"tileheight"`
+	Infinite    bool           `json:"infinite"`
+	Orientation string         `json:"orientation"`
+	Layers      []tiledLayer   `json:"layers"`
+	Tilesets    []tiledTileset `json:"tilesets"`
+}
+
+type tiledLayer struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	Width       int             `json:"width"`
+	Height      int             `json:"height"`
+	Encoding    string          `json:"encoding"`
+	Compression string          `json:"compression"`
+	Data        json.RawMessage `json:"data"`
+	Objects     []tiledObject   `json:"objects"`
+	Layers      []tiledLayer    `json:"layers"`
+	Properties  []tiledProperty `json:"properties"`
+}
+
+type tiledObject struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Class      string          `json:"class"`
+	GID        uint32          `json:"gid"`
+	X          float64         `json:"x"`
+	Y          float64         `json:"y"`
+	Width      float64         `json:"width"`
+	Height     float64         `json:"height"`
+	Properties []tiledProperty `json:"properties"`
+}
+
+type tiledTileset struct {
+	FirstGID uint32      `json:"firstgid"`
+	Source   string      `json:"source"`
+	Tiles    []tiledTile `json:"tiles"`
+}
+
+type tiledTile struct {
+	ID         uint32          `json:"id"`
+	Type       string          `json:"type"`
+	Class      string          `json:"class"`
+	Properties []tiledProperty `json:"properties"`
+}
+
+type tiledProperty struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// tileInfo is what a global tile id resolves to.  Tiles without a
+// type have no Kind.  They can only be placed on the map when they
+// have the "collides" property, which is all that they change.
+type tileInfo struct {
+	kind     Kind
+	typed    bool
+	collides *bool
+}
+
+// ReadTiled decodes a map that was exported from the Tiled editor in
+// its JSON format (.tmj or .json).
+//
+// Tile layers named "objects" are placed on the Objects layer, and all
+// other tile layers are placed on the Floor.  Tiles in a tileset are
+// converted into a Kind by their type (or class), so a tile with the
+// type "water" becomes Water.  Placing a tile that isn't in any
+// tileset is an error.
+//
+// The custom boolean property "collides" can be set on a tileset
+// tile, a tile layer, or an object.  It overrides whether the
+// affected tiles are blocked.  Tiles that have the property but no
+// type, like the ones usually painted on a collision layer, leave the
+// Kind of the map alone, and only change whether it is blocked.  Any
+// other tile without a type is an error.
+func ReadTiled(r io.Reader, name string) (*Map, error) {
+	var t tiledMap
+	err := json.NewDecoder(r).Decode(&t)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if t.Infinite {
+		return nil, fmt.Errorf("infinite maps are not supported")
+	}
+	if t.Orientation != "" && t.Orientation != "orthogonal" {
+		return nil, fmt.Errorf("unsupported orientation %q", t.Orientation)
+	}
+	if t.Width <= 0 || t.Height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions %dx%d", t.Width, t.Height)
+	}
+
+	tiles, err := t.tileInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	m := New(name, t.Width, t.Height)
+	for _, l := range flattenLayers(t.Layers) {
+		switch l.Type {
+		case "tilelayer":
+			err = t.applyTileLayer(m, l, tiles)
+		case "objectgroup":
+			err = t.applyObjectGroup(m, l, tiles)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("layer %q: %v", l.Name, err)
+		}
+	}
+	return m, m.Validate()
+}
+
+// flattenLayers expands group layers, so that their children are
+// handled in the same order that Tiled draws them.
+func flattenLayers(layers []tiledLayer) []tiledLayer {
+	var out []tiledLayer
+	for _, l := range layers {
+		if l.Type == "group" {
+			out = append(out, flattenLayers(l.Layers)...)
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// tileInfo builds a lookup table from global tile ids to tile kinds
+// using the embedded tilesets.
+func (t *tiledMap) tileInfo() (map[uint32]tileInfo, error) {
+	info := map[uint32]tileInfo{}
+	for _, ts := range t.Tilesets {
+		if ts.Source != "" {
+			return nil, fmt.Errorf(
+				"external tileset %q is not supported; embed it in the map",
+				ts.Source)
+		}
+		for _, tile := range ts.Tiles {
+			gid := ts.FirstGID + tile.ID
+			ti := tileInfo{}
+			class := tile.Type
+			if class == "" {
+				class = tile.Class
+			}
+			if class != "" {
+				k, err := ParseKind(class)
+				if err != nil {
+					return nil, fmt.Errorf("tileset tile %d: %v", tile.ID, err)
+				}
+				ti.kind, ti.typed = k, true
+			}
+			collides, err := boolProperty(tile.Properties, collidesProperty)
+			if err != nil {
+				return nil, fmt.Errorf("tileset tile %d: %v", tile.ID, err)
+			}
+			ti.collides = collides
+			info[gid] = ti
+		}
+	}
+	return info, nil
+}
+
+// lookup resolves a global tile id.  Ids that aren't in a tileset, or
+// whose tile has neither a type nor the "collides" property, are
+// errors, since there is no telling what they were meant to be.
+func lookup(tiles map[uint32]tileInfo, gid uint32) (tileInfo, error) {
+	gid &^= tiledFlagMask
+	ti, ok := tiles[gid]
+	if !ok {
+		return ti, fmt.Errorf("tile id %d is not in any tileset", gid)
+	}
+	if !ti.typed && ti.collides == nil {
+		return ti, fmt.Errorf("tile id %d has no type or %q property", gid, collidesProperty)
+	}
+	return ti, nil
+}
+
+// layerData reads the tile ids of a tile layer, which need to be saved
+// as a plain array.
+func layerData(l tiledLayer) ([]uint32, error) {
+	if (l.Encoding != "" && l.Encoding != "csv") || l.Compression != "" {
+		return nil, fmt.Errorf(
+			"unsupported layer encoding %q, compression %q; export as CSV",
+			l.Encoding, l.Compression)
+	}
+	var data []uint32
+	if len(l.Data) > 0 {
+		if err := json.Unmarshal(l.Data, &data); err != nil {
+			return nil, fmt.Errorf("invalid tile data: %v", err)
+		}
+	}
+	return data, nil
+}
+
+func (t *tiledMap) applyTileLayer(m *Map, l tiledLayer, tiles map[uint32]tileInfo) error {
+	data, err := layerData(l)
+	if err != nil {
+		return err
+	}
+	if len(data) != t.Width*t.Height {
+		return fmt.Errorf("expected %d tiles, got %d",
+			t.Width*t.Height, len(data))
+	}
+	layerCollides, err := boolProperty(l.Properties, collidesProperty)
+	if err != nil {
+		return err
+	}
+	target := Floor
+	if strings.EqualFold(l.Name, "objects") {
+		target = Objects
+	}
+	for i, gid := range data {
+		if gid == 0 {
+			continue
+		}
+		x, y := i%t.Width, i/t.Width
+		ti, err := lookup(tiles, gid)
+		if err != nil {
+			return fmt.Errorf("cell (%d,%d): %v", x, y, err)
+		}
+		if ti.typed {
+			m.Set(target, x, y, ti.kind)
+		}
+		if ti.collides != nil {
+			m.SetBlocked(x, y, *ti.collides)
+		}
+		if layerCollides != nil {
+			m.SetBlocked(x, y, *layerCollides)
+		}
+	}
+	return nil
+}
+
+func (t *tiledMap) applyObjectGroup(m *Map, l tiledLayer, tiles map[uint32]tileInfo) error {
+	if t.TileWidth <= 0 || t.TileHeight <= 0 {
+		return fmt.Errorf("map needs a tile size to place objects")
+	}
+	for _, o := range l.Objects {
+		collides, err := boolProperty(o.Properties, collidesProperty)
+		if err != nil {
+			return fmt.Errorf("object %q: %v", o.Name, err)
+		}
+
+		// Tile objects are anchored at their bottom-left corner,
+		// while all other objects are anchored at the top-left.
+		x0 := int(o.X) / t.TileWidth
+		y0 := int(o.Y) / t.TileHeight
+		if o.GID != 0 {
+			y0 = (int(o.Y) - 1) / t.TileHeight
+			ti, err := lookup(tiles, o.GID)
+			if err != nil {
+				return fmt.Errorf("object %q: %v", o.Name, err)
+			}
+			if ti.typed {
+				m.Set(Objects, x0, y0, ti.kind)
+			}
+			if ti.collides != nil {
+				m.SetBlocked(x0, y0, *ti.collides)
+			}
+			if collides != nil {
+				m.SetBlocked(x0, y0, *collides)
+			}
+			continue
+		}
+		if collides == nil {
+			continue
+		}
+		x1 := int(o.X+o.Width-1) / t.TileWidth
+		y1 := int(o.Y+o.Height-1) / t.TileHeight
+		for y := y0; y <= y1; y++ {
+			for x := x0; x <= x1; x++ {
+				m.SetBlocked(x, y, *collides)
+			}
+		}
+	}
+	return nil
+}
+
+// boolProperty finds a boolean custom property by name.  It returns
+// nil if the property isn't set.
+func boolProperty(props []tiledProperty, name string) (*bool, error) {
+	for _, p := range props {
+		if p.Name != name {
+			continue
+		}
+		b, ok := p.Value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("property %q must be a bool, got %T",
+				name, p.Value)
+		}
+		return &b, nil
+	}
+	return nil, nil
+}
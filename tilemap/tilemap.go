@@ -0,0 +1,197 @@
+package tilemap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kind identifies the terrain or object that occupies a tile.  The
+// zero value, None, means that nothing is there.
+type Kind int
+
+const (
+	None Kind = iota
+	Grass
+	Dirt
+	Sand
+	Water
+	Wall
+	Tree
+	Rock
+)
+
+var kindNames = map[Kind]string{
+	None:  "none",
+	Grass: "grass",
+	Dirt:  "dirt",
+	Sand:  "sand",
+	Water: "water",
+	Wall:  "wall",
+	Tree:  "tree",
+	Rock:  "rock",
+}
+
+// blockingKinds is the set of tile kinds that can't be walked on.
+// Anything not in this set is walkable.
+var blockingKinds = map[Kind]bool{
+	Water: true,
+	Wall:  true,
+	Tree:  true,
+	Rock:  true,
+}
+
+func (k Kind) String() string {
+	if s, ok := kindNames[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// ParseKind converts a name like "grass" or a number like "1" into a
+// Kind.  Names are not case sensitive.
+func ParseKind(s string) (Kind, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for k, name := range kindNames {
+		if s == name {
+			return k, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return None, fmt.Errorf("unknown tile kind %q", s)
+	}
+	if _, ok := kindNames[Kind(n)]; !ok {
+		return None, fmt.Errorf("unknown tile kind %d", n)
+	}
+	return Kind(n), nil
+}
+
+// Walkable reports whether a player can stand on a tile of this kind.
+func (k Kind) Walkable() bool {
+	return !blockingKinds[k]
+}
+
+// Layer selects one of the stacked grids of a Map.  The Floor is
+// drawn first, and Objects are drawn on top of it.
+type Layer int
+
+const (
+	Floor Layer = iota
+	Objects
+)
+
+// Map is a rectangular grid of tiles.  Every layer is stored in
+// row-major order, so the tile at (x,y) is found at index
+// (y * Width + x).
+//
+// Blocked holds the collision flag of each tile.  It is computed from
+// the tile kinds whenever Set is called, but can be overridden with
+// SetBlocked for tiles that should behave differently than their
+// kind suggests.
+type Map struct {
+	Name    string
+	Width   int
+	Height  int
+	Floor   []Kind
+	Objects []Kind
+	Blocked []bool
+}
+
+// New creates an empty map with the given dimensions.  Every tile is
+// None, and nothing is blocked.
+func New(name string, width, height int) *Map {
+	n := width * height
+	return &Map{
+		Name:    name,
+		Width:   width,
+		Height:  height,
+		Floor:   make([]Kind, n),
+		Objects: make([]Kind, n),
+		Blocked: make([]bool, n),
+	}
+}
+
+// NewRoom creates a map covered with grass, and surrounded by walls.
+// It is used as the world when no other map has been loaded.
+func NewRoom(name string, width, height int) *Map {
+	m := New(name, width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			m.Set(Floor, x, y, Grass)
+			if x == 0 || y == 0 || x == width-1 || y == height-1 {
+				m.Set(Objects, x, y, Wall)
+			}
+		}
+	}
+	return m
+}
+
+// Validate checks that the dimensions of the map agree with the
+// length of each of its layers.
+func (m *Map) Validate() error {
+	if m.Width <= 0 || m.Height <= 0 {
+		return fmt.Errorf("invalid dimensions %dx%d", m.Width, m.Height)
+	}
+	n := m.Width * m.Height
+	if len(m.Floor) != n || len(m.Objects) != n || len(m.Blocked) != n {
+		return fmt.Errorf("layers do not match dimensions %dx%d",
+			m.Width, m.Height)
+	}
+	return nil
+}
+
+// InBounds reports whether (x,y) lies within the map.
+func (m *Map) InBounds(x, y int) bool {
+	return x >= 0 && y >= 0 && x < m.Width && y < m.Height
+}
+
+func (m *Map) index(x, y int) int {
+	return y*m.Width + x
+}
+
+func (m *Map) layer(l Layer) []Kind {
+	switch l {
+	case Floor:
+		return m.Floor
+	case Objects:
+		return m.Objects
+	}
+	return nil
+}
+
+// At returns the kind of tile at (x,y) on the given layer.  Tiles
+// outside of the map are None.
+func (m *Map) At(l Layer, x, y int) Kind {
+	tiles := m.layer(l)
+	if tiles == nil || !m.InBounds(x, y) {
+		return None
+	}
+	return tiles[m.index(x, y)]
+}
+
+// Set changes the kind of tile at (x,y) on the given layer, and
+// recomputes whether that tile is blocked.  Positions outside of the
+// map are ignored.
+func (m *Map) Set(l Layer, x, y int, k Kind) {
+	tiles := m.layer(l)
+	if tiles == nil || !m.InBounds(x, y) {
+		return
+	}
+	i := m.index(x, y)
+	tiles[i] = k
+	m.Blocked[i] = !m.Floor[i].Walkable() || !m.Objects[i].Walkable()
+}
+
+// SetBlocked overrides the collision flag of the tile at (x,y).
+func (m *Map) SetBlocked(x, y int, blocked bool) {
+	if m.InBounds(x, y) {
+		m.Blocked[m.index(x, y)] = blocked
+	}
+}
+
+// Walkable reports whether a player can move onto the tile at (x,y).
+// Tiles outside of the map are never walkable.
+func (m *Map) Walkable(x, y int) bool {
+	return m.InBounds(x, y) && !m.Blocked[m.index(x, y)]
+}
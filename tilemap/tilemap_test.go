@@ -0,0 +1,61 @@
+package tilemap
+
+import "testing"
+
+func TestRoomWalls(t *testing.T) {
+	m := NewRoom("test", 5, 4)
+
+	cases := []struct {
+		x, y     int
+		walkable bool
+	}{
+		{0, 0, false},
+		{4, 3, false},
+		{2, 0, false},
+		{1, 1, true},
+		{3, 2, true},
+		{-1, 2, false},
+		{5, 2, false},
+		{2, 4, false},
+	}
+	for _, c := range cases {
+		if got := m.Walkable(c.x, c.y); got != c.walkable {
+			t.Errorf("Walkable(%d, %d) = %v, expected %v",
+				c.x, c.y, got, c.walkable)
+		}
+	}
+}
+
+func TestSetRecomputesBlocked(t *testing.T) {
+	m := NewRoom("test", 5, 5)
+
+	m.Set(Objects, 2, 2, Rock)
+	if m.Walkable(2, 2) {
+		t.Error("a rock was placed, but the tile is still walkable.")
+	}
+
+	m.Set(Objects, 2, 2, None)
+	if !m.Walkable(2, 2) {
+		t.Error("the rock was removed, but the tile is still blocked.")
+	}
+
+	m.Set(Floor, 2, 2, Water)
+	if m.Walkable(2, 2) {
+		t.Error("water should block movement.")
+	}
+
+	m.SetBlocked(2, 2, false)
+	if !m.Walkable(2, 2) {
+		t.Error("SetBlocked(false) did not override the water tile.")
+	}
+}
+
+func TestAtOutOfBounds(t *testing.T) {
+	m := NewRoom("test", 3, 3)
+	if k := m.At(Floor, 10, 10); k != None {
+		t.Errorf("expected None outside of the map, got %v", k)
+	}
+	if k := m.At(Objects, 0, 0); k != Wall {
+		t.Errorf("expected Wall in the corner, got %v", k)
+	}
+}
@@ -2,7 +2,11 @@ package wshandle
 
 import (
 	"github.com/gorilla/websocket"
+	"github.com/tilegame/gameserver/codec"
+	"io"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,13 +22,17 @@ const (
 
 	// Maximum message size allowed from peer.
 	maxMessageSize = 512
+
+	// Number of outgoing messages that can be queued for a client.
+	sendBufferSize = 256
 )
 
-var idnum = 123
+var idnum int64 = 123
 
+// nextId returns a new client id.  Clients connect from many HTTP
+// handlers at once, so the counter is only changed atomically.
 func nextId() int {
-	idnum++
-	return idnum
+	return int(atomic.AddInt64(&idnum, 1))
 }
 
 // Client represents a client connection, and the means of communicating
@@ -33,43 +41,172 @@ func nextId() int {
 //
 //  	fmt.Fprintln(exampleClient, "hello there!")
 //
+//
+// The messages written to a Client are plain text, which clients that
+// chose a binary codec as their websocket subprotocol receive as a
+// string.  Values are sent with Send instead, which encodes them with
+// the client's codec.
+//
+// The Identity is who the ClientRoom's Authenticate function said the
+// client is.  It is empty when the room doesn't authenticate anyone.
 type Client struct {
-	Id   int
-	room *ClientRoom
-	conn *websocket.Conn
-	send chan []byte
+	Id int
+	Identity
+	room      *ClientRoom
+	conn      *websocket.Conn
+	codec     codec.Codec
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // Unregister informs the Clientroom that this client is leaving.
 // Then, it closes the websocket connection.
 func (c *Client) Unregister() {
 	c.room.remove <- c
+	c.close()
 	c.conn.Close()
 }
 
+// close tells the writePump to stop.  It is safe to call more than
+// once, which happens when a client is dropped by the ClientRoom and
+// then unregisters itself.
+func (c *Client) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
 // Write to the Client is safe for concurrent use, because it sends the byte array
-// through a channel instead of writing it directly to the socket.
+// through a channel instead of writing it directly to the socket.  Writing to a
+// client that has disconnected returns io.ErrClosedPipe.
 func (c *Client) Write(p []byte) (int, error) {
-	n := len(p)
-	b := make([]byte, len(p))
+	b := make(text, len(p))
 	copy(b, p)
-	c.send <- b
-	return n, nil
+	if err := c.Send(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Send encodes a value with the client's codec, and sends it.  Like
+// Write, it is safe for concurrent use, and doesn't wait for a client
+// that can't keep up, which is dropped instead.
+func (c *Client) Send(v interface{}) error {
+	b, err := encode(c.codec, v)
+	if err != nil {
+		return err
+	}
+	return c.queue(b)
+}
+
+// SendTo sends the same value to each of the clients.  It is only
+// encoded once for each codec that the clients use.  Clients that have
+// disconnected are skipped, and the ones that can't keep up are
+// dropped, so SendTo never waits on a client.
+func SendTo(clients []*Client, v interface{}) {
+	e := newEncoder(v)
+	for _, c := range clients {
+		b, err := e.encode(c.codec)
+		if err != nil {
+			log.Printf("sending to client %d: %v", c.Id, err)
+			continue
+		}
+		c.queue(b)
+	}
+}
+
+// text is a message that was written with Write.  It is sent as it is
+// to the JSON clients, and as a string to the others.
+type text []byte
+
+// encode encodes a message with a codec.
+func encode(cd codec.Codec, v interface{}) ([]byte, error) {
+	if t, ok := v.(text); ok {
+		if cd == codec.JSON {
+			return t, nil
+		}
+		return cd.Marshal(string(t))
+	}
+	return cd.Marshal(v)
+}
+
+// encoder encodes a message with each codec that it is asked for, but
+// only once, so that the encoding can be shared by all of the clients
+// that use the codec.
+type encoder struct {
+	v    interface{}
+	done map[codec.Codec][]byte
+}
+
+func newEncoder(v interface{}) *encoder {
+	return &encoder{v: v, done: map[codec.Codec][]byte{}}
+}
+
+func (e *encoder) encode(cd codec.Codec) ([]byte, error) {
+	if b, ok := e.done[cd]; ok {
+		return b, nil
+	}
+	b, err := encode(cd, e.v)
+	if err != nil {
+		return nil, err
+	}
+	e.done[cd] = b
+	return b, nil
+}
+
+// queue sends a message that is already encoded for the client,
+// without waiting.  A client that is so far behind that its buffer is
+// full is dropped, instead of holding up the messages to everyone else.
+func (c *Client) queue(b []byte) error {
+	select {
+	case <-c.done:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case c.send <- b:
+		return nil
+	default:
+		log.Printf("client %d can't keep up, dropping it.", c.Id)
+		c.close()
+		return io.ErrClosedPipe
+	}
+}
+
+// Codec returns the codec that the client chose, which is codec.JSON
+// unless it asked for something else.
+func (c *Client) Codec() codec.Codec {
+	return c.codec
 }
 
 // NewClient creates a new client and run its respective goroutines.
 // Pass a reference to the ClientRoom that this client will join,
 // and a reference to the websocket connection itself.
+// The codec is the one named by the subprotocol of the connection.
 func NewClient(room *ClientRoom, conn *websocket.Conn) *Client {
+	cd, ok := codec.Lookup(conn.Subprotocol())
+	if !ok {
+		cd = codec.JSON
+	}
 	client := &Client{
-		Id:   nextId(),
-		room: room,
-		conn: conn,
-		send: make(chan []byte),
+		Id:    nextId(),
+		room:  room,
+		conn:  conn,
+		codec: cd,
+		send:  make(chan []byte, sendBufferSize),
+		done:  make(chan struct{}),
 	}
 	return client
 }
 
+// Identity is the user behind a Client, as found from the session of
+// the websocket handshake.  Session is anything else that the
+// Authenticate function wants to remember about it.
+type Identity struct {
+	Username string
+	PlayerID int
+	Session  interface{}
+}
+
 /*
 	___________________________________
 	              Internals
@@ -114,8 +251,9 @@ func (c *Client) readPump() {
 
 		// send a message down the admin channel.
 		c.room.Messages <- Message{
-			Id:   c.Id,
-			Data: message,
+			Id:     c.Id,
+			Data:   message,
+			Client: c,
 		}
 	}
 }
@@ -127,11 +265,21 @@ func (c *Client) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
+		case <-c.done:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			// binary messages can't be joined together, so each of
+			// them gets a frame of its own.
+			if c.codec.Binary() {
+				if err := c.conn.WriteMessage(websocket.BinaryMessage, message); err != nil {
+					return
+				}
+				continue
 			}
 
 			w, err := c.conn.NextWriter(websocket.TextMessage)
@@ -3,13 +3,19 @@ package wshandle
 import (
 	"log"
 	"net/http"
+	"sort"
+	"sync"
 
 	"github.com/gorilla/websocket"
+	"github.com/tilegame/gameserver/codec"
 )
 
+// Message is a websocket frame that was received from a Client.  The
+// Data is encoded with the client's codec.
 type Message struct {
-	Id   int
-	Data []byte
+	Id     int
+	Data   []byte
+	Client *Client
 }
 
 // ClientRoom handles the list of active clients, and allows messages to be
@@ -19,10 +25,20 @@ type Message struct {
 //
 // Printing to the ClientRoom itself will broadcast a message to all of the
 // clients in the clientroom.
+//
+// If Authenticate is set, it is called with the request of each new
+// connection, before the websocket handshake.  Connections that it
+// returns an error for are turned away with 401 Unauthorized, and the
+// others are given the Identity it returns.  Headers that it sets on
+// the ResponseWriter, like a new cookie, are sent with the handshake.
+// It should be set before the room starts handling connections.
 type ClientRoom struct {
-	Messages  chan Message
+	Messages     chan Message
+	Authenticate func(w http.ResponseWriter, r *http.Request) (Identity, error)
+
+	mu        sync.RWMutex // guards clientmap, which only run changes.
 	clientmap map[int]*Client
-	broadcast chan []byte
+	broadcast chan interface{}
 	add       chan *Client
 	remove    chan *Client
 	upgrader  websocket.Upgrader
@@ -34,7 +50,7 @@ func NewClientRoom() *ClientRoom {
 	room := &ClientRoom{
 		Messages:  make(chan Message),
 		clientmap: map[int]*Client{},
-		broadcast: make(chan []byte),
+		broadcast: make(chan interface{}),
 		add:       make(chan *Client),
 		remove:    make(chan *Client),
 		upgrader:  makeUpgrader(),
@@ -47,23 +63,35 @@ func (r *ClientRoom) run() {
 	for {
 		select {
 		case client := <-r.add:
+			r.mu.Lock()
 			r.clientmap[client.Id] = client
+			r.mu.Unlock()
 			log.Println("client added:", client)
 
 		case client := <-r.remove:
+			r.mu.Lock()
 			delete(r.clientmap, client.Id)
+			r.mu.Unlock()
 			log.Println("client removed:", client)
 
 		case message := <-r.broadcast:
-			log.Printf("broadcasting: %s", message)
+			if t, ok := message.(text); ok {
+				log.Printf("broadcasting: %s", t)
+			}
+
+			// the message is encoded once for each codec in use.
+			e := newEncoder(message)
 			for _, client := range r.clientmap {
-				select {
-				case client.send <- message:
-					// message was successfully sent. continue.
-				default:
-					// something's wrong.  close connection.
-					close(client.send)
+				b, err := e.encode(client.codec)
+				if err != nil {
+					log.Printf("broadcasting as %s: %v", client.codec.Name(), err)
+					continue
+				}
+				if err := client.queue(b); err != nil {
+					// the client is gone, or too slow to keep up.
+					r.mu.Lock()
 					delete(r.clientmap, client.Id)
+					r.mu.Unlock()
 				}
 			}
 		}
@@ -71,20 +99,40 @@ func (r *ClientRoom) run() {
 	}
 }
 
+// Write broadcasts a message to every client.  Like Client.Write, the
+// message is plain text, which is sent as a string to the clients that
+// use a binary codec.
 func (r *ClientRoom) Write(p []byte) (int, error) {
 	n := len(p)
-	b := make([]byte, len(p))
+	b := make(text, len(p))
 	copy(b, p)
 	r.broadcast <- b
 	return n, nil
 }
 
+// Send broadcasts a value to every client, encoded once for each of the
+// codecs that they use.  The value is encoded after Send returns, so it
+// shouldn't be changed afterwards.
+func (r *ClientRoom) Send(v interface{}) {
+	r.broadcast <- v
+}
+
 // Handle is the HTTP/WebSocket handler for a given instance of a
 // ClientRoom.
 func (room *ClientRoom) Handle(w http.ResponseWriter, r *http.Request) {
 	log.Println("new connection:", r.RemoteAddr)
 
-	conn, err := room.upgrader.Upgrade(w, r, nil)
+	var id Identity
+	if room.Authenticate != nil {
+		var err error
+		if id, err = room.Authenticate(w, r); err != nil {
+			log.Println(r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	conn, err := room.upgrader.Upgrade(w, r, w.Header())
 	if err != nil {
 		log.Println(err)
 		return
@@ -93,6 +141,7 @@ func (room *ClientRoom) Handle(w http.ResponseWriter, r *http.Request) {
 	// fmt.Fprintln(room, "Welcome:", r.RemoteAddr)
 
 	client := NewClient(room, conn)
+	client.Identity = id
 	room.add <- client
 
 	go client.readPump()
@@ -101,7 +150,8 @@ func (room *ClientRoom) Handle(w http.ResponseWriter, r *http.Request) {
 
 func makeUpgrader() websocket.Upgrader {
 	return websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+		CheckOrigin:  func(r *http.Request) bool { return true },
+		Subprotocols: codec.Subprotocols(),
 	}
 }
 
@@ -110,6 +160,23 @@ func makeUpgrader() websocket.Upgrader {
 // this is basically just looking up the client in the map, it returns
 // (*Client, bool), similar to the way a map would.
 func (room *ClientRoom) Client(id int) (*Client, bool) {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
 	c, ok := room.clientmap[id]
 	return c, ok
 }
+
+// Clients returns the clients in the room, sorted by their id numbers.
+// It is useful for sending each client a message of its own.
+func (room *ClientRoom) Clients() []*Client {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	clients := make([]*Client, 0, len(room.clientmap))
+	for _, c := range room.clientmap {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Id < clients[j].Id
+	})
+	return clients
+}
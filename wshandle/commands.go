@@ -0,0 +1,86 @@
+package wshandle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/tilegame/gameserver/codec"
+	"github.com/tilegame/gameserver/commander"
+)
+
+type clientKey struct{}
+
+// NewContext returns a copy of ctx that carries the client.  Commands
+// called by ServeCommands are given a context made this way.
+func NewContext(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// ClientFromContext returns the client that sent the command, if there
+// is one.  Commands can use it to find out who called them.
+func ClientFromContext(ctx context.Context) (*Client, bool) {
+	c, ok := ctx.Value(clientKey{}).(*Client)
+	return c, ok
+}
+
+// ServeCommands reads the Messages sent by the clients in the room, and
+// calls each of them as a command in the given command center.  The
+// response is written back to the client that sent the command.
+// Messages are handled one at a time, in the order that they arrive.
+//
+// JSON-RPC 2.0 requests and batches are answered with JSON-RPC
+// responses, and notifications aren't answered at all.  Any other
+// message is treated as a Command or a function string, and answered
+// with a Response.  Messages are decoded, and their answers encoded,
+// with the codec of the client that sent them.
+//
+// Commands that take a context.Context as their first parameter can
+// retrieve the calling client with ClientFromContext.
+//
+// ServeCommands never returns, so it should be run in its own
+// goroutine.  Until it is running, clients will block on every message
+// they send.
+func (r *ClientRoom) ServeCommands(center *commander.Center) {
+	for m := range r.Messages {
+		ctx := NewContext(context.Background(), m.Client)
+		resp := handleCommand(ctx, center, m.Client.codec, m.Data)
+		if resp == nil {
+			continue
+		}
+		err := m.Client.Send(resp)
+		if err != nil && err != io.ErrClosedPipe {
+			log.Printf("ServeCommands: client %d: %v", m.Id, err)
+			err = m.Client.Send(encodingFailed(resp, err))
+		}
+		if err != nil {
+			log.Printf("ServeCommands: client %d: %v", m.Id, err)
+		}
+	}
+}
+
+// handleCommand calls the command in a message, and returns what to
+// answer with, if anything.
+func handleCommand(ctx context.Context, center *commander.Center, cd codec.Codec, data []byte) interface{} {
+	if cd == codec.JSON {
+		if commander.IsJSONRPC(data) {
+			return center.HandleJSONRPC(ctx, data)
+		}
+		return center.Handle(ctx, data)
+	}
+	var v interface{}
+	if err := cd.Unmarshal(data, &v); err != nil {
+		return commander.Response{Error: fmt.Sprintf("invalid %s message: %v", cd.Name(), err)}
+	}
+	return center.HandleValue(ctx, v)
+}
+
+// encodingFailed is sent in place of an answer that couldn't be
+// encoded, in the same form as the answer.
+func encodingFailed(resp interface{}, err error) interface{} {
+	if _, ok := resp.(commander.Response); ok {
+		return commander.Response{Error: err.Error()}
+	}
+	return commander.RPCInternalError(err)
+}
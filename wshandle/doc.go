@@ -33,6 +33,53 @@ Messages can be broadcast to all the Clients in the ClientRoom:
 Individual messages can be sent to a specific Client:
 	fmt.Fprintln(client, "hello, you!")
 
+Values, like the state of the game, are sent with Send, which encodes
+them with the codec of each client:
+	clientroom.Send(state)
+	client.Send(state)
+
+
+
+
+Commands
+
+Messages sent by the clients arrive on the ClientRoom's Messages channel.
+To treat them as commands, connect the room to a commander.Center:
+	go clientroom.ServeCommands(center)
+
+Each message is called as a command, and the Response is written back
+to the client that sent it.
+
+
+
+
+Binary Encodings
+
+Clients can choose MessagePack or CBOR instead of JSON by asking for
+the "msgpack" or "cbor" websocket subprotocol.  ServeCommands decodes
+their Messages with that codec, and everything sent to them is encoded
+with it directly, once for each codec rather than once for each client.
+See package codec for the details.
+
+
+
+
+Sessions
+
+A ClientRoom can check who is connecting before it accepts them, by
+setting its Authenticate function.  For example, to only let in the
+clients with a valid session cookie:
+	room.Authenticate = func(w http.ResponseWriter, r *http.Request) (wshandle.Identity, error) {
+		s, err := cookieServer.Authenticate(w, r)
+		if err != nil {
+			return wshandle.Identity{}, err
+		}
+		return wshandle.Identity{Username: s.Name, PlayerID: s.PlayerID}, nil
+	}
+
+Everyone else gets 401 Unauthorized.  Commands find the Identity of the
+client that sent them through ClientFromContext.
+
 
 
 
@@ -44,8 +91,6 @@ and importing gorilla/websockets should _only_ be done in this package.
 
 	TODO:
 	- List active clients.
-	- Match Client with PlayerSessions
-	- Send incoming messages to GameCommandCenter
 	- make it obvious what the main ClientRoom object is called, and how
 	  it will be publicly accessible from the rest of the game.
 